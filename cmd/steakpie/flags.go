@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// options holds the server's settings, from flags or their environment variables.
+type options struct {
+	configPath string // empty means findConfig picks one
+	listen     string // host:port, or unix:/path for a unix socket
+	dbPath     string
+	secretFile string // empty means WEBHOOK_SECRET holds the secret
+	logFormat  string // text or json
+	dryRun     bool
+}
+
+// defaultListen is the address the server listens on when none is given.
+const defaultListen = ":3142"
+
+// envFallbacks names the environment variable each flag falls back to when
+// it isn't given on the command line.
+var envFallbacks = []struct{ flag, env string }{
+	{"config", "STEAKPIE_CONFIG"},
+	{"listen", "STEAKPIE_LISTEN"},
+	{"db", "DB_PATH"},
+	{"secret-file", "WEBHOOK_SECRET_FILE"},
+	{"log-format", "STEAKPIE_LOG_FORMAT"},
+	{"dry-run", "STEAKPIE_DRY_RUN"},
+}
+
+// parseFlags reads the server's options from args, falling back to the
+// environment for flags that aren't given. --help prints the usage to
+// stderr and returns flag.ErrHelp.
+func parseFlags(args []string, stderr io.Writer) (options, error) {
+	var opts options
+	flags := flag.NewFlagSet("steakpie", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&opts.configPath, "config", "", "config file or config.d directory (default config.yml, config.yaml or config.d)")
+	flags.StringVar(&opts.listen, "listen", defaultListen, "address to listen on, host:port or unix:/path/to.sock")
+	flags.StringVar(&opts.dbPath, "db", "db.sqlite", "SQLite database for deduplicating deliveries")
+	flags.StringVar(&opts.secretFile, "secret-file", "", "file holding the webhook secret, instead of WEBHOOK_SECRET")
+	flags.StringVar(&opts.logFormat, "log-format", "text", "log format, text or json")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "log the commands each webhook would run instead of running them")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, "Usage:\n"+
+			"  steakpie [flags]                        run the webhook server\n"+
+			"  steakpie validate [path]                check a config\n"+
+			"  steakpie plan <payload.json> [config]   show what a payload would run\n\n"+
+			"Flags:\n")
+		flags.PrintDefaults()
+		fmt.Fprint(stderr, "\nEach flag falls back to an environment variable when it isn't given:\n")
+		for _, f := range envFallbacks {
+			fmt.Fprintf(stderr, "  --%-12s %s\n", f.flag, f.env)
+		}
+		fmt.Fprint(stderr, "\nPORT sets the port when neither --listen nor STEAKPIE_LISTEN is given.\n"+
+			"WEBHOOK_SECRET holds the webhook secret when no secret file is given.\n"+
+			"WATCH_CONFIG=true reloads the config when its files change.\n")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return opts, err
+	}
+	if flags.NArg() > 0 {
+		return opts, fmt.Errorf("unexpected argument %q, see steakpie --help", flags.Arg(0))
+	}
+
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, f := range envFallbacks {
+		v := os.Getenv(f.env)
+		if v == "" || set[f.flag] {
+			continue
+		}
+		if err := flags.Set(f.flag, v); err != nil {
+			return opts, fmt.Errorf("%s=%q: %w", f.env, v, err)
+		}
+		set[f.flag] = true
+	}
+	if port := os.Getenv("PORT"); port != "" && !set["listen"] {
+		opts.listen = ":" + port
+	}
+
+	if opts.logFormat != "text" && opts.logFormat != "json" {
+		return opts, fmt.Errorf("log format must be text or json, got %q", opts.logFormat)
+	}
+	return opts, nil
+}
+
+// jsonLog writes each log line as a JSON object with the time and message.
+type jsonLog struct {
+	w io.Writer
+}
+
+func (j jsonLog) Write(p []byte) (int, error) {
+	line, err := json.Marshal(struct {
+		Time    string `json:"time"`
+		Message string `json:"message"`
+	}{time.Now().UTC().Format(time.RFC3339Nano), strings.TrimSuffix(string(p), "\n")})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := j.w.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/jc/steakpie/internal/config"
 	"github.com/jc/steakpie/internal/executor"
@@ -12,75 +17,197 @@ import (
 )
 
 func main() {
-	if err := run(); err != nil {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(validate(os.Args[2:], os.Stdout, os.Stderr))
+		case "plan":
+			os.Exit(plan(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+	if err := run(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
 		fmt.Fprintf(os.Stderr, "\n❌ Error: %v\n\n", err)
 		os.Exit(1)
 	}
 }
 
+// findConfig returns the config file in the current directory or, without
+// one, the config.d directory.
 func findConfig() (string, error) {
 	for _, name := range []string{"config.yml", "config.yaml"} {
 		if _, err := os.Stat(name); err == nil {
 			return name, nil
 		}
 	}
+	if info, err := os.Stat("config.d"); err == nil && info.IsDir() {
+		return "config.d", nil
+	}
 	return "", fmt.Errorf("no config file found\n\n" +
-		"Place a config.yml (or config.yaml) in the current directory.\n\n" +
+		"Place a config.yml (or config.yaml) in the current directory,\n" +
+		"or one file per team in a config.d directory,\n" +
+		"or give its path with --config.\n\n" +
 		"Example:\n" +
 		"  WEBHOOK_SECRET=secret steakpie\n\n" +
-		"Optional environment variables:\n" +
-		"  DB_PATH - Path to SQLite database (default: db.sqlite)")
+		"Run steakpie --help for the other flags and their environment variables.")
 }
 
-func run() error {
-	secret := os.Getenv("WEBHOOK_SECRET")
-	if secret == "" {
-		return fmt.Errorf("WEBHOOK_SECRET environment variable is required\n\n" +
-			"Please set it before running:\n" +
-			"  WEBHOOK_SECRET=your-secret-here steakpie\n\n" +
-			"This secret is used to verify webhook signatures from GitHub.")
+func run(args []string) error {
+	opts, err := parseFlags(args, os.Stderr)
+	if err != nil {
+		return err
+	}
+	if opts.logFormat == "json" {
+		log.SetFlags(0)
+		log.SetOutput(jsonLog{os.Stderr})
 	}
 
-	configPath, err := findConfig()
+	loaded, err := readSecrets(opts.secretFile)
 	if err != nil {
 		return err
 	}
+	log.Printf("✓ Loaded %d webhook secret(s), key IDs %s", len(loaded), keyIDs(loaded))
+
+	// Secrets from a file are reloaded along with the config
+	var secrets webhook.SecretProvider = webhook.Secrets(loaded)
+	var liveSecrets *webhook.LiveSecrets
+	if opts.secretFile != "" {
+		liveSecrets = webhook.NewLiveSecrets(opts.secretFile, loaded)
+		secrets = liveSecrets
+	}
+
+	configPath := opts.configPath
+	if configPath == "" {
+		if configPath, err = findConfig(); err != nil {
+			return err
+		}
+	}
 
 	cfg, err := config.Load(configPath)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	if err := cfg.CheckPrivileges(); err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
 
 	log.Printf("✓ Loaded config with %d package(s)", len(cfg))
 
-	// Initialize event store for webhook deduplication
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = "db.sqlite"
+	// Reload the config on SIGHUP, and on file changes if asked to
+	watch := false
+	if v := os.Getenv("WATCH_CONFIG"); v != "" {
+		watch, err = strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("WATCH_CONFIG must be true or false, got %q", v)
+		}
 	}
 
-	store, err := webhook.NewEventStore(dbPath)
+	live := config.NewLive(configPath, cfg)
+	go handleReloads(live, configPath, liveSecrets, watch)
+
+	if watch {
+		log.Printf("✓ Watching %s for changes (SIGHUP also reloads)", reloadable(configPath, liveSecrets))
+	} else {
+		log.Printf("✓ Send SIGHUP to reload %s", reloadable(configPath, liveSecrets))
+	}
+
+	// Initialize event store for webhook deduplication.
+	// A dry run records deliveries apart from real ones and only logs commands
+	newStore := webhook.NewEventStore
+	var runner executor.Runner = executor.ShellRunner{}
+	if opts.dryRun {
+		newStore = webhook.NewDryRunEventStore
+		runner = executor.DryRunner{}
+	}
+
+	store, err := newStore(opts.dbPath)
 	if err != nil {
 		return fmt.Errorf("failed to initialize event store: %w", err)
 	}
 	defer store.Close()
 
-	log.Printf("✓ Initialized event store at %s", dbPath)
+	log.Printf("✓ Initialized event store at %s", opts.dbPath)
+	if opts.dryRun {
+		log.Printf("✓ Dry run: commands will be logged, not run")
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3142"
+	ln, err := listen(opts.listen)
+	if err != nil {
+		return fmt.Errorf("failed to start server: %w", err)
 	}
+	defer ln.Close()
 
-	runner := executor.ShellRunner{}
-	http.Handle("/version/1", webhook.Handler([]byte(secret), cfg, store, runner))
+	http.Handle("/version/1", webhook.Handler(secrets, live, store, runner))
 
-	log.Printf("✓ Server starting on port %s", port)
-	log.Printf("✓ Webhook endpoint: http://localhost:%s/version/1", port)
+	log.Printf("✓ Server listening on %s", opts.listen)
+	log.Printf("✓ Webhook endpoint: %s", endpoint(opts.listen))
 
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	if err := http.Serve(ln, nil); err != nil {
 		return fmt.Errorf("failed to start server: %w", err)
 	}
 
 	return nil
 }
+
+// envKeyID is the key ID of the secret given in WEBHOOK_SECRET.
+const envKeyID = "env"
+
+// readSecrets returns the webhook secrets from the file at path or, when path
+// is empty, the single secret in WEBHOOK_SECRET.
+func readSecrets(path string) ([]webhook.Secret, error) {
+	if path != "" {
+		return webhook.ReadSecrets(path)
+	}
+
+	secret := os.Getenv("WEBHOOK_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("WEBHOOK_SECRET environment variable is required\n\n" +
+			"Please set it before running:\n" +
+			"  WEBHOOK_SECRET=your-secret-here steakpie\n\n" +
+			"or point --secret-file (WEBHOOK_SECRET_FILE) at a file holding one secret per line.\n\n" +
+			"This secret is used to verify webhook signatures from GitHub.")
+	}
+	return []webhook.Secret{{KeyID: envKeyID, Key: []byte(secret)}}, nil
+}
+
+// keyIDs lists the key IDs of secrets for the log.
+func keyIDs(secrets []webhook.Secret) string {
+	ids := make([]string, len(secrets))
+	for i, secret := range secrets {
+		ids[i] = secret.KeyID
+	}
+	return strings.Join(ids, ", ")
+}
+
+// unixPrefix marks a listen address as the path of a unix socket.
+const unixPrefix = "unix:"
+
+// listen opens addr, which is host:port or unix:/path. A socket left behind
+// at the path by an earlier run is replaced.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Type() == os.ModeSocket {
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// endpoint describes where GitHub should send webhooks for a listen address.
+func endpoint(addr string) string {
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		return fmt.Sprintf("/version/1 on unix socket %s", path)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("http://%s/version/1", net.JoinHostPort(host, port))
+}
@@ -1,6 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -60,7 +66,7 @@ const validConfig = "mypackage:\n  - echo hello\n"
 func TestRun_MissingWebhookSecret(t *testing.T) {
 	unsetEnv(t, "WEBHOOK_SECRET")
 
-	err := run()
+	err := run(nil)
 	if err == nil {
 		t.Fatal("expected error when WEBHOOK_SECRET is not set, got nil")
 	}
@@ -77,7 +83,7 @@ func TestRun_MissingWebhookSecret(t *testing.T) {
 func TestRun_EmptyWebhookSecret(t *testing.T) {
 	setEnv(t, "WEBHOOK_SECRET", "")
 
-	err := run()
+	err := run(nil)
 	if err == nil {
 		t.Fatal("expected error when WEBHOOK_SECRET is empty, got nil")
 	}
@@ -92,7 +98,7 @@ func TestRun_NoConfigFilePresent(t *testing.T) {
 	setEnv(t, "WEBHOOK_SECRET", "test-secret")
 	chdir(t, t.TempDir())
 
-	err := run()
+	err := run(nil)
 	if err == nil {
 		t.Fatal("expected error when no config file exists, got nil")
 	}
@@ -157,7 +163,7 @@ func TestRun_InvalidConfigPath(t *testing.T) {
 	setEnv(t, "WEBHOOK_SECRET", "test-secret")
 	chdir(t, dir)
 
-	err := run()
+	err := run(nil)
 	if err == nil {
 		t.Fatal("expected error when config file is empty, got nil")
 	}
@@ -175,7 +181,7 @@ func TestRun_InvalidYAMLConfig(t *testing.T) {
 	setEnv(t, "WEBHOOK_SECRET", "test-secret")
 	chdir(t, dir)
 
-	err := run()
+	err := run(nil)
 	if err == nil {
 		t.Fatal("expected error when config file is invalid YAML, got nil")
 	}
@@ -185,3 +191,343 @@ func TestRun_InvalidYAMLConfig(t *testing.T) {
 		t.Errorf("error message should mention config loading failure, got: %s", errMsg)
 	}
 }
+
+func TestRun_InvalidWatchConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "config.yml", "mypackage:\n  run:\n    /tmp:\n      - echo hello\n")
+
+	setEnv(t, "WEBHOOK_SECRET", "test-secret")
+	setEnv(t, "WATCH_CONFIG", "sometimes")
+	chdir(t, dir)
+
+	err := run(nil)
+	if err == nil {
+		t.Fatal("expected error when WATCH_CONFIG is not a boolean, got nil")
+	}
+
+	errMsg := err.Error()
+	if !strings.Contains(errMsg, "WATCH_CONFIG") {
+		t.Errorf("error message should mention WATCH_CONFIG, got: %s", errMsg)
+	}
+}
+
+func TestFindConfig_ConfDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "config.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "config.d"), "team.yml", validConfig)
+	chdir(t, dir)
+
+	path, err := findConfig()
+	if err != nil {
+		t.Fatal("expected config.d to be found, got error:", err)
+	}
+	if path != "config.d" {
+		t.Errorf("expected path config.d, got %s", path)
+	}
+}
+
+func TestStampConfig_IncludesConfDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "config.yml", validConfig)
+	if err := os.Mkdir(filepath.Join(dir, "config.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yml")
+	before := stampConfig(path)
+
+	writeFile(t, filepath.Join(dir, "config.d"), "team.yml", "web:\n  run:\n    /srv/web:\n      - echo web\n")
+	after := stampConfig(path)
+
+	if _, ok := after[filepath.Join(dir, "config.d", "team.yml")]; !ok {
+		t.Errorf("expected the new file to be stamped, got %v", after)
+	}
+	if len(before) != 2 || len(after) != 3 {
+		t.Errorf("expected the config file and config.d, then the new file too, got %d and %d stamps", len(before), len(after))
+	}
+}
+
+func TestValidate_Valid(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "config.yml", "app:\n  run:\n    "+dir+":\n      - echo hello\n")
+
+	var stdout, stderr strings.Builder
+	if code := validate([]string{filepath.Join(dir, "config.yml")}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit status 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "is valid, with 1 package(s)") {
+		t.Errorf("expected a success message, got: %s", stdout.String())
+	}
+}
+
+func TestValidate_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "position of an invalid value",
+			content: "app:\n  run:\n    /tmp:\n      - cmd: date\n        timeout: soon\n",
+			want:    "config.yml:5:18: package app: directory /tmp: timeout: invalid duration \"soon\"",
+		},
+		{
+			name:    "unknown package key",
+			content: "app:\n  runn:\n    /tmp:\n      - date\n",
+			want:    "config.yml:2:3: package app: unknown package key \"runn\"",
+		},
+		{
+			name:    "unknown template field",
+			content: "app:\n  run:\n    /tmp:\n      - date\n      - echo {{.Nope}}\n",
+			want:    "config.yml:5:9: package app: directory /tmp: command \"echo {{.Nope}}\": ",
+		},
+		{
+			name:    "duplicate package",
+			content: "app:\n  run:\n    /tmp: [date]\napp:\n  run:\n    /tmp: [date]\n",
+			want:    "already defined",
+		},
+		{
+			name:    "missing directory",
+			content: "app:\n  run:\n    /nonexistent/steakpie-test:\n      - date\n",
+			want:    "config.yml:3:5: package app: directory /nonexistent/steakpie-test: stat /nonexistent/steakpie-test: no such file or directory",
+		},
+		{
+			name:    "empty command",
+			content: "app:\n  run:\n    /tmp:\n      - \" \"\n",
+			want:    "config.yml:4:9: package app: directory /tmp: command must not be empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, dir, "config.yml", tt.content)
+
+			var stdout, stderr strings.Builder
+			if code := validate([]string{filepath.Join(dir, "config.yml")}, &stdout, &stderr); code != 1 {
+				t.Fatalf("expected exit status 1, got %d", code)
+			}
+			if !strings.Contains(stderr.String(), tt.want) {
+				t.Errorf("expected output to contain %q, got: %s", tt.want, stderr.String())
+			}
+		})
+	}
+}
+
+func TestValidate_Usage(t *testing.T) {
+	var stdout, stderr strings.Builder
+	if code := validate([]string{"a", "b"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit status 2, got %d", code)
+	}
+}
+
+func TestPlan_PrintsTree(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "config.yml", "hello-world:\n  run:\n    /srv/app:\n      - echo {{.Tag}} > deployed\n")
+	payload, err := filepath.Abs("../../testdata/registry_package_published.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, dir)
+
+	var stdout, stderr strings.Builder
+	if code := plan([]string{payload}, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit status 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "1. echo latest > deployed") {
+		t.Errorf("expected the rendered command, got:\n%s", stdout.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "deployed")); err == nil {
+		t.Error("expected plan not to run the command")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "db.sqlite")); err == nil {
+		t.Error("expected plan not to create the database")
+	}
+}
+
+func TestPlan_Usage(t *testing.T) {
+	var stdout, stderr strings.Builder
+	if code := plan(nil, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit status 2, got %d", code)
+	}
+}
+
+func TestRun_InvalidDryRun(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "config.yml", "mypackage:\n  run:\n    /tmp:\n      - echo hello\n")
+
+	setEnv(t, "WEBHOOK_SECRET", "test-secret")
+	setEnv(t, "STEAKPIE_DRY_RUN", "maybe")
+	chdir(t, dir)
+
+	err := run(nil)
+	if err == nil || !strings.Contains(err.Error(), `STEAKPIE_DRY_RUN="maybe"`) {
+		t.Errorf("expected an error about STEAKPIE_DRY_RUN, got: %v", err)
+	}
+
+	// The flag takes precedence over the environment
+	setEnv(t, "WATCH_CONFIG", "sometimes")
+	err = run([]string{"--dry-run"})
+	if err == nil || !strings.Contains(err.Error(), "WATCH_CONFIG") {
+		t.Errorf("expected --dry-run to override STEAKPIE_DRY_RUN, got: %v", err)
+	}
+}
+
+func TestRun_UnknownFlag(t *testing.T) {
+	if err := run([]string{"--no-such-flag"}); err == nil {
+		t.Error("expected an error for an unknown flag")
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	for _, env := range []string{"STEAKPIE_CONFIG", "STEAKPIE_LISTEN", "DB_PATH", "WEBHOOK_SECRET_FILE", "STEAKPIE_LOG_FORMAT", "STEAKPIE_DRY_RUN", "PORT"} {
+		unsetEnv(t, env)
+	}
+
+	opts, err := parseFlags(nil, io.Discard)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	expected := options{listen: ":3142", dbPath: "db.sqlite", logFormat: "text"}
+	if opts != expected {
+		t.Errorf("expected defaults %+v, got %+v", expected, opts)
+	}
+
+	setEnv(t, "STEAKPIE_CONFIG", "/etc/steakpie/config.yml")
+	setEnv(t, "STEAKPIE_LISTEN", "127.0.0.1:9000")
+	setEnv(t, "DB_PATH", "/var/lib/steakpie/db.sqlite")
+	setEnv(t, "STEAKPIE_DRY_RUN", "true")
+	opts, err = parseFlags([]string{"--listen", "unix:/run/steakpie.sock", "--log-format=json", "--secret-file", "/etc/steakpie/secret"}, io.Discard)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	expected = options{
+		configPath: "/etc/steakpie/config.yml",
+		listen:     "unix:/run/steakpie.sock",
+		dbPath:     "/var/lib/steakpie/db.sqlite",
+		secretFile: "/etc/steakpie/secret",
+		logFormat:  "json",
+		dryRun:     true,
+	}
+	if opts != expected {
+		t.Errorf("expected flags to override the environment, %+v, got %+v", expected, opts)
+	}
+}
+
+func TestParseFlags_Port(t *testing.T) {
+	unsetEnv(t, "STEAKPIE_LISTEN")
+	setEnv(t, "PORT", "8080")
+
+	opts, err := parseFlags(nil, io.Discard)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if opts.listen != ":8080" {
+		t.Errorf("expected PORT to set the port, got %s", opts.listen)
+	}
+
+	opts, err = parseFlags([]string{"--listen", "127.0.0.1:3142"}, io.Discard)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if opts.listen != "127.0.0.1:3142" {
+		t.Errorf("expected --listen to win over PORT, got %s", opts.listen)
+	}
+}
+
+func TestParseFlags_Errors(t *testing.T) {
+	unsetEnv(t, "STEAKPIE_LOG_FORMAT")
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"log format", []string{"--log-format", "xml"}, `log format must be text or json, got "xml"`},
+		{"stray argument", []string{"serve"}, `unexpected argument "serve"`},
+		{"unknown flag", []string{"--port", "80"}, "flag provided but not defined: -port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseFlags(tt.args, io.Discard)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestParseFlags_Help(t *testing.T) {
+	var out strings.Builder
+	_, err := parseFlags([]string{"--help"}, &out)
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("expected flag.ErrHelp, got: %v", err)
+	}
+	for _, want := range []string{"-listen", "unix:/path/to.sock", "STEAKPIE_LISTEN", "WEBHOOK_SECRET_FILE", "steakpie validate"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected help to mention %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestReadSecrets(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "secret", "from-file\n")
+	setEnv(t, "WEBHOOK_SECRET", "from-env")
+
+	if secrets, err := readSecrets(filepath.Join(dir, "secret")); err != nil || len(secrets) != 1 || string(secrets[0].Key) != "from-file" {
+		t.Errorf("expected the file's secret, got %v, %v", secrets, err)
+	}
+	if secrets, err := readSecrets(""); err != nil || len(secrets) != 1 || string(secrets[0].Key) != "from-env" || secrets[0].KeyID != envKeyID {
+		t.Errorf("expected WEBHOOK_SECRET without a file, got %v, %v", secrets, err)
+	}
+
+	setEnv(t, "WEBHOOK_SECRET", "")
+	if _, err := readSecrets(""); err == nil {
+		t.Error("expected an error without a file or WEBHOOK_SECRET")
+	}
+}
+
+func TestListen_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "steakpie.sock")
+	for range 2 {
+		// The second time round replaces the socket left behind by the first
+		ln, err := listen("unix:" + path)
+		if err != nil {
+			t.Fatalf("expected to listen on %s, got: %v", path, err)
+		}
+		if ln.Addr().Network() != "unix" {
+			t.Errorf("expected a unix socket, got %s", ln.Addr().Network())
+		}
+		ln.(*net.UnixListener).SetUnlinkOnClose(false)
+		ln.Close()
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	tests := map[string]string{
+		":3142":             "http://localhost:3142/version/1",
+		"127.0.0.1:9000":    "http://127.0.0.1:9000/version/1",
+		"[::1]:3142":        "http://[::1]:3142/version/1",
+		"unix:/run/sp.sock": "/version/1 on unix socket /run/sp.sock",
+	}
+	for addr, want := range tests {
+		if got := endpoint(addr); got != want {
+			t.Errorf("endpoint(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestJSONLog(t *testing.T) {
+	var out strings.Builder
+	logger := log.New(jsonLog{&out}, "", 0)
+	logger.Printf("✓ Loaded config with %d package(s)", 2)
+
+	var line struct{ Time, Message string }
+	if err := json.Unmarshal([]byte(out.String()), &line); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", out.String(), err)
+	}
+	if line.Message != "✓ Loaded config with 2 package(s)" || line.Time == "" {
+		t.Errorf("expected time and message, got %+v", line)
+	}
+}
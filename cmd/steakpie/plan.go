@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jc/steakpie/internal/config"
+	"github.com/jc/steakpie/internal/webhook"
+)
+
+// planDeliveryID stands in for the delivery ID GitHub would send, which
+// commands see as STEAKPIE_DELIVERY_ID.
+const planDeliveryID = "plan"
+
+// plan implements `steakpie plan <payload.json> [config]`: it prints what the
+// server would do with the payload under the config at path, or the one
+// findConfig picks, without running anything or touching the database.
+// It returns the exit status, like validate.
+func plan(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(stderr, "usage: steakpie plan <payload.json> [config]")
+		return 2
+	}
+
+	body, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	var path string
+	if len(args) == 2 {
+		path = args[1]
+	} else if path, err = findConfig(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if err := webhook.Plan(stdout, body, planDeliveryID, cfg); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
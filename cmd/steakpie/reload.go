@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jc/steakpie/internal/config"
+	"github.com/jc/steakpie/internal/webhook"
+)
+
+// watchInterval is how often the config file is checked for changes when WATCH_CONFIG is set.
+const watchInterval = 2 * time.Second
+
+// handleReloads reloads the config, and the secret file when secrets is set,
+// on SIGHUP. If watch is set it also reloads either of them whenever the size
+// or modification time of any of its files changes, including the config.d
+// directory itself when files are added or removed. It never returns.
+func handleReloads(live *config.Live, path string, secrets *webhook.LiveSecrets, watch bool) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if watch {
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	last := stampConfig(path)
+	var lastSecrets fileStamp
+	if secrets != nil {
+		lastSecrets = stampFile(secrets.Path())
+	}
+	for {
+		select {
+		case <-hup:
+			log.Printf("Received SIGHUP, reloading %s", reloadable(path, secrets))
+			reloadConfig(live)
+			if secrets != nil {
+				reloadSecrets(secrets)
+			}
+		case <-tick:
+			if stamp := stampConfig(path); !maps.Equal(stamp, last) {
+				last = stamp
+				log.Printf("Config file %s changed, reloading", path)
+				reloadConfig(live)
+			}
+			if secrets == nil {
+				continue
+			}
+			if stamp := stampFile(secrets.Path()); stamp != lastSecrets {
+				lastSecrets = stamp
+				log.Printf("Secret file %s changed, reloading", secrets.Path())
+				reloadSecrets(secrets)
+			}
+		}
+	}
+}
+
+// reloadable names what SIGHUP reloads: the config at path and the secret
+// file, if there is one.
+func reloadable(path string, secrets *webhook.LiveSecrets) string {
+	if secrets == nil {
+		return path
+	}
+	return path + " and " + secrets.Path()
+}
+
+// reloadConfig swaps in the config file's current contents and logs what changed.
+// If the file doesn't load, the running config is kept.
+func reloadConfig(live *config.Live) {
+	changes, err := live.Reload()
+	if err != nil {
+		log.Printf("✗ Config reload failed, keeping current config: %v", err)
+		return
+	}
+
+	log.Printf("✓ Reloaded config with %d package(s)", len(live.Current()))
+	if len(changes) == 0 {
+		log.Printf("  no changes")
+	}
+	for _, change := range changes {
+		log.Printf("  %s", change)
+	}
+}
+
+// reloadSecrets swaps in the secret file's current contents and logs the key
+// IDs now accepted. If the file can't be read, the running secrets are kept.
+func reloadSecrets(secrets *webhook.LiveSecrets) {
+	loaded, err := secrets.Reload()
+	if err != nil {
+		log.Printf("✗ Secret reload failed, keeping current secrets: %v", err)
+		return
+	}
+	log.Printf("✓ Reloaded %d webhook secret(s), key IDs %s", len(loaded), keyIDs(loaded))
+}
+
+// fileStamp identifies a version of a file by its size and modification time.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// stampConfig returns the stamps of every file loading the config at path reads.
+// If the config doesn't load, the files read before the error are still stamped.
+func stampConfig(path string) map[string]fileStamp {
+	files, _ := config.Sources(path)
+	if len(files) == 0 {
+		files = []string{path}
+	}
+	stamps := make(map[string]fileStamp, len(files))
+	for _, file := range files {
+		stamps[file] = stampFile(file)
+	}
+	return stamps
+}
+
+// stampFile returns the file's current stamp, or the zero stamp if it can't be read.
+func stampFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{size: info.Size(), modTime: info.ModTime()}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jc/steakpie/internal/config"
+)
+
+// validate implements `steakpie validate [path]`: it loads the config at path,
+// or the one findConfig picks, checks it, and prints every problem to stderr.
+// It returns the exit status: 0 when the config is valid, 1 when it isn't,
+// and 2 for bad usage.
+func validate(args []string, stdout, stderr io.Writer) int {
+	if len(args) > 1 {
+		fmt.Fprintln(stderr, "usage: steakpie validate [path]")
+		return 2
+	}
+
+	var path string
+	if len(args) == 1 {
+		path = args[0]
+	} else {
+		var err error
+		if path, err = findConfig(); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	problems := cfg.Check()
+	for _, err := range problems {
+		fmt.Fprintln(stderr, err)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "✓ %s is valid, with %d package(s)\n", path, len(cfg))
+	return 0
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Check looks for problems Load can't rule out by reading the config alone:
+// directories that don't exist or can't be read, and directories or commands
+// with nothing to run. It returns every problem found, in package order, with
+// the file, line and column of the directory or command at fault.
+func (c Config) Check() []error {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var problems []error
+	for _, name := range names {
+		for _, dirs := range c[name].phases() {
+			for _, d := range dirs {
+				for _, err := range d.check() {
+					err = fmt.Errorf("package %s: directory %s: %w", name, d.Dir, err)
+					if d.Pos.File != "" {
+						err = inFile(d.Pos.File, err)
+					}
+					problems = append(problems, err)
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// check returns the problems with a single directory.
+func (d Directory) check() []error {
+	var problems []error
+	if d.Dir != "" {
+		if err := readableDir(d.Dir); err != nil {
+			problems = append(problems, d.Pos.mark(err))
+		}
+	}
+	if len(d.Commands) == 0 {
+		problems = append(problems, d.Pos.mark(fmt.Errorf("no commands to run")))
+	}
+	return append(problems, checkCommandText(d.Commands)...)
+}
+
+// checkCommandText returns an error for every blank command, including those in branches.
+func checkCommandText(cmds []Command) []error {
+	var problems []error
+	for _, cmd := range cmds {
+		if cmd.Argv == nil && strings.TrimSpace(cmd.Cmd) == "" {
+			problems = append(problems, cmd.Pos.mark(fmt.Errorf("command must not be empty")))
+		}
+		for _, branch := range cmd.branches() {
+			problems = append(problems, checkCommandText(branch)...)
+		}
+	}
+	return problems
+}
+
+// readableDir returns an error unless path is a directory steakpie can list.
+func readableDir(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Readdirnames(1); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -2,24 +2,104 @@ package config
 
 import (
 	"fmt"
-	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Command represents a command to execute, optionally with child commands
-// that only run if the parent succeeds.
+// that only run if the parent succeeds, OnFailure commands that only run if it
+// fails, and Always commands that run either way.
 type Command struct {
-	Cmd      string
-	Children []Command
+	// Cmd is the command template to run, after ${...} expansion, with the
+	// expanded values escaped so they render as they are. For a command given
+	// as Argv it holds the words joined by spaces.
+	Cmd string
+	// Raw is the command as written, set only when ${...} expansion changed
+	// it. Logs and plans show it in place of Cmd; see Shown.
+	Raw string
+
+	// A command with a Name is a step that siblings can list in their Needs;
+	// a command waits for the steps it needs and is skipped if any of them fail.
+	Name  string
+	Needs []string
+
+	// Use names a template that Load expands in the command's place, filling
+	// in its ${with.NAME} parameters from With.
+	Use  string
+	With map[string]string
+
+	// Argv, when set, is run directly, without a shell.
+	Argv []string
+	// Env adds to the directory's environment.
+	Env Env
+
+	Children  []Command
+	OnFailure []Command
+	Always    []Command
+
+	// Timeout bounds the total run time and IdleTimeout the time without
+	// output; zero falls back to the directory's setting.
+	Timeout     time.Duration
+	IdleTimeout time.Duration
+
+	// A failed command is re-run up to Retries times, at most MaxRetries,
+	// waiting Backoff before the first retry and doubling the wait after each one.
+	Retries int
+	Backoff time.Duration
+
+	// Capture names the variable that passes the command's trimmed stdout to
+	// its branches and later siblings, as $STEAKPIE_OUT_<Capture> and
+	// {{.Outputs.<Capture>}}.
+	Capture string
+
+	// A command succeeds if it exits with one of SuccessExitCodes (just 0
+	// when empty). Output matching FailIfOutputMatches makes it fail, and
+	// output matching SucceedIfOutputMatches makes it succeed, whatever the
+	// exit status.
+	SuccessExitCodes       []int
+	FailIfOutputMatches    *regexp.Regexp
+	SucceedIfOutputMatches *regexp.Regexp
+
+	// Pos is where the command is defined, for errors. A template's commands
+	// take the position of the command using the template.
+	Pos Pos
+}
+
+// Shown returns the command as logs, plans and errors should show it: as
+// written, without the values ${...} expanded to, which may be secrets.
+func (c Command) Shown() string {
+	if c.Raw != "" {
+		return c.Raw
+	}
+	return c.Cmd
 }
 
+// MaxRetries bounds a command's retries, so that a typo can't keep a deploy
+// retrying for days.
+const MaxRetries = 20
+
 // UnmarshalYAML implements custom YAML unmarshaling for Command.
-// It handles two forms:
+// It handles three forms:
 //   - Scalar: "echo hello" → Command{Cmd: "echo hello"}
 //   - Sequence: ["parent", "child1", "child2"] → Command with children
 //     The first element is the parent command, the rest are children.
+//   - Mapping: {cmd: "parent", timeout: 5m, retries: 3, children: [...]} → Command with settings
+//     argv: [docker, compose, up, -d] takes the place of cmd to run a program without a shell.
+//     on_failure and always take command lists, like children.
+//     name and needs turn sibling commands into steps of a dependency graph.
+//     use and with take the place of everything but name and needs to expand a template.
 func (c *Command) UnmarshalYAML(node *yaml.Node) error {
+	c.Pos = nodePos(node)
+	return at(node, c.decode(node))
+}
+
+// decode fills the command from any of the forms UnmarshalYAML accepts.
+func (c *Command) decode(node *yaml.Node) error {
 	switch node.Kind {
 	case yaml.ScalarNode:
 		c.Cmd = node.Value
@@ -47,45 +127,417 @@ func (c *Command) UnmarshalYAML(node *yaml.Node) error {
 		}
 		return nil
 
+	case yaml.MappingNode:
+		var other string
+		for i := 0; i < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			var err error
+			switch key.Value {
+			case "use", "with", "name", "needs":
+			default:
+				other = key.Value
+			}
+			switch key.Value {
+			case "use":
+				c.Use = value.Value
+			case "with":
+				if value.Kind != yaml.MappingNode {
+					err = fmt.Errorf("expected a mapping of parameter names to values")
+				} else {
+					err = value.Decode(&c.With)
+				}
+			case "cmd":
+				c.Cmd = value.Value
+			case "name":
+				c.Name = value.Value
+			case "needs":
+				c.Needs, err = decodeStrings(value)
+			case "argv":
+				err = value.Decode(&c.Argv)
+				if err == nil && len(c.Argv) == 0 {
+					err = fmt.Errorf("must not be empty")
+				}
+			case "env", "env_file":
+				err = c.Env.decode(key.Value, value)
+			case "children":
+				err = value.Decode(&c.Children)
+			case "on_failure":
+				err = value.Decode(&c.OnFailure)
+			case "always":
+				err = value.Decode(&c.Always)
+			case "timeout":
+				c.Timeout, err = decodeDuration(value)
+			case "idle_timeout":
+				c.IdleTimeout, err = decodeDuration(value)
+			case "retries":
+				err = value.Decode(&c.Retries)
+				if err == nil && c.Retries < 0 {
+					err = fmt.Errorf("must not be negative")
+				} else if err == nil && c.Retries > MaxRetries {
+					err = fmt.Errorf("must be at most %d", MaxRetries)
+				}
+			case "backoff":
+				c.Backoff, err = decodeDuration(value)
+			case "capture":
+				c.Capture = value.Value
+				if !varName.MatchString(c.Capture) {
+					err = fmt.Errorf("%q is not a valid name, use letters, digits and underscores", c.Capture)
+				}
+			case "success_exit_codes":
+				c.SuccessExitCodes, err = decodeExitCodes(value)
+			case "fail_if_output_matches":
+				c.FailIfOutputMatches, err = decodeRegexp(value)
+			case "succeed_if_output_matches":
+				c.SucceedIfOutputMatches, err = decodeRegexp(value)
+			default:
+				return at(key, fmt.Errorf("unknown command key %q", key.Value))
+			}
+			if err != nil {
+				return at(value, fmt.Errorf("%s: %w", key.Value, err))
+			}
+		}
+		switch {
+		case c.Use != "" && other != "":
+			return fmt.Errorf("%s can't be set alongside use, only name and needs can", other)
+		case c.Use != "":
+			return nil
+		case c.With != nil:
+			return fmt.Errorf("with is only allowed alongside use")
+		case c.Cmd != "" && c.Argv != nil:
+			return fmt.Errorf("command mapping must have either cmd or argv, not both")
+		case c.Argv != nil:
+			c.Cmd = strings.Join(c.Argv, " ")
+		case c.Cmd == "":
+			return fmt.Errorf("command mapping is missing cmd")
+		}
+		return nil
+
 	default:
 		return fmt.Errorf("unexpected YAML node kind %d for command", node.Kind)
 	}
 }
 
+// branches returns the command's children, on_failure and always commands.
+func (c Command) branches() [][]Command {
+	return [][]Command{c.Children, c.OnFailure, c.Always}
+}
+
+// eachCommand calls f for every command in cmds and their branches.
+func eachCommand(cmds []Command, f func(*Command)) {
+	for i := range cmds {
+		f(&cmds[i])
+		for _, branch := range cmds[i].branches() {
+			eachCommand(branch, f)
+		}
+	}
+}
+
+// decodeDuration accepts a Go duration string such as "90s" or "5m",
+// or a plain number of seconds.
+func decodeDuration(node *yaml.Node) (time.Duration, error) {
+	if node.Kind != yaml.ScalarNode {
+		return 0, fmt.Errorf("expected a duration")
+	}
+	if seconds, err := strconv.Atoi(node.Value); err == nil {
+		if seconds < 0 {
+			return 0, fmt.Errorf("duration must not be negative")
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", node.Value)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration must not be negative")
+	}
+	return d, nil
+}
+
+// decodeExitCodes accepts a single exit code or a list of them.
+func decodeExitCodes(node *yaml.Node) ([]int, error) {
+	var codes []int
+	switch node.Kind {
+	case yaml.ScalarNode:
+		var code int
+		if err := node.Decode(&code); err != nil {
+			return nil, err
+		}
+		codes = []int{code}
+	case yaml.SequenceNode:
+		if err := node.Decode(&codes); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("expected an exit code or a list of exit codes")
+	}
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("must not be empty")
+	}
+	for _, code := range codes {
+		if code < 0 || code > 255 {
+			return nil, fmt.Errorf("exit code %d is out of range 0-255", code)
+		}
+	}
+	return codes, nil
+}
+
+// decodeRegexp compiles a regular expression.
+func decodeRegexp(node *yaml.Node) (*regexp.Regexp, error) {
+	if node.Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("expected a regular expression")
+	}
+	re, err := regexp.Compile(node.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", node.Value, err)
+	}
+	return re, nil
+}
+
+// Defaults holds the top-level defaults section, which applies to every package.
+// Directories without their own timeouts inherit these, and packages without
+// their own shell use Shell.
+type Defaults struct {
+	Timeout     time.Duration
+	IdleTimeout time.Duration
+	Shell       Shell
+}
+
+// UnmarshalYAML implements custom YAML unmarshaling for Defaults.
+func (d *Defaults) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return at(node, fmt.Errorf("defaults must be a mapping"))
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		var err error
+		switch key.Value {
+		case "timeout":
+			d.Timeout, err = decodeDuration(value)
+		case "idle_timeout":
+			d.IdleTimeout, err = decodeDuration(value)
+		case "shell":
+			err = value.Decode(&d.Shell)
+		default:
+			return at(key, fmt.Errorf("unknown defaults key %q", key.Value))
+		}
+		if err != nil {
+			return at(value, fmt.Errorf("%s: %w", key.Value, err))
+		}
+	}
+	return nil
+}
+
 // PackageConfig holds the configuration for a single package.
+// Setup commands must all succeed before any run directory is touched.
+// Teardown commands always execute afterwards, even when run steps failed.
+// MaxParallel limits how many commands run at once; zero means no limit.
+// Tags lists the container tags the package reacts to, optionally each with
+// their own phases; empty means only "latest".
+// PayloadStdin pipes the webhook payload to each command's standard input.
+// Shell runs the package's command strings; nil means the default, bash -lc.
+// Env applies to every command of the package. Commands inherit steakpie's
+// environment, or with CleanEnv only the variables listed in AllowEnv;
+// WEBHOOK_SECRET is never passed on. User and Group apply to directories
+// that don't set their own.
 type PackageConfig struct {
-	Run map[string][]Command `yaml:"run"`
+	Setup        Directories `yaml:"setup"`
+	Run          Directories `yaml:"run"`
+	Teardown     Directories `yaml:"teardown"`
+	MaxParallel  int         `yaml:"max_parallel"`
+	Tags         TagRules    `yaml:"tags"`
+	PayloadStdin bool        `yaml:"payload_stdin"`
+	Shell        Shell       `yaml:"shell"`
+	Env          Env         `yaml:",inline"`
+	CleanEnv     bool        `yaml:"clean_env"`
+	AllowEnv     []string    `yaml:"allow_env"`
+	User         string      `yaml:"user"`
+	Group        string      `yaml:"group"`
+}
+
+// packageKeys lists the keys a package mapping may have.
+var packageKeys = []string{
+	"setup", "run", "teardown", "max_parallel", "tags", "payload_stdin", "shell",
+	"env", "env_file", "clean_env", "allow_env", "user", "group",
+}
+
+// UnmarshalYAML implements custom YAML unmarshaling for PackageConfig,
+// rejecting keys it doesn't know so typos don't go unnoticed.
+func (p *PackageConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			if key := node.Content[i]; !slices.Contains(packageKeys, key.Value) {
+				return at(key, fmt.Errorf("unknown package key %q", key.Value))
+			}
+		}
+	}
+	type plain PackageConfig
+	return at(node, node.Decode((*plain)(p)))
+}
+
+// HasCommands reports whether any phase of the package has commands to run.
+func (p PackageConfig) HasCommands() bool {
+	return len(p.Setup) > 0 || len(p.Run) > 0 || len(p.Teardown) > 0
+}
+
+// phases returns every list of directories in the package, including those of tag rules.
+func (p PackageConfig) phases() []Directories {
+	all := []Directories{p.Setup, p.Run, p.Teardown}
+	for _, rule := range p.Tags {
+		all = append(all, rule.Setup, rule.Run, rule.Teardown)
+	}
+	return all
+}
+
+// setFile records the file the package was loaded from in the positions of
+// its directories and commands.
+func (p *PackageConfig) setFile(file string) {
+	for _, dirs := range p.phases() {
+		for i := range dirs {
+			dirs[i].Pos.File = file
+			eachCommand(dirs[i].Commands, func(c *Command) { c.Pos.File = file })
+		}
+	}
+}
+
+// applyDefaults fills in settings the package and its directories leave unset.
+func (p *PackageConfig) applyDefaults(defaults Defaults) {
+	if p.Shell == nil {
+		p.Shell = defaults.Shell
+	}
+	for _, dirs := range p.phases() {
+		for i := range dirs {
+			if dirs[i].Timeout == 0 {
+				dirs[i].Timeout = defaults.Timeout
+			}
+			if dirs[i].IdleTimeout == 0 {
+				dirs[i].IdleTimeout = defaults.IdleTimeout
+			}
+		}
+	}
 }
 
 // Config represents the application configuration.
 // It maps package names to their configuration.
 type Config map[string]PackageConfig
 
-// Load reads and parses a YAML configuration file.
+// Reserved top-level keys: defaultsKey holds settings shared by all packages,
+// templatesKey the command templates they can use, and includeKey the other
+// config files to load.
+const (
+	defaultsKey  = "defaults"
+	templatesKey = "templates"
+	includeKey   = "include"
+)
+
+// Load reads and parses a YAML configuration file, along with the files it
+// includes and those in the config.d directory next to it. path may also be a
+// directory, in which case every config file in it is loaded.
+// Every top-level key is a package name, except for the reserved defaults,
+// templates and include keys. Packages and templates may only be defined once
+// across all files, and defaults set in one. Commands that use a template are
+// replaced by it first. ${VAR}, ${VAR:-default} and ${file:/path} in
+// directories and commands are expanded while loading, command templates are
+// checked, and users and groups are looked up. Errors name the file they come from.
 func Load(path string) (Config, error) {
-	data, err := os.ReadFile(path)
+	sources, err := readSources(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return nil, err
+	}
+
+	var defaults Defaults
+	var defaultsFrom string
+	templates := make(Templates)
+	templatesFrom := make(map[string]string)
+	type pkgNode struct {
+		name, file string
+		node       *yaml.Node
+	}
+	var pkgs []pkgNode
+	pkgsFrom := make(map[string]string)
+
+	for _, src := range sources {
+		doc := src.doc
+		for i := 0; i < len(doc.Content); i += 2 {
+			name, value := doc.Content[i].Value, doc.Content[i+1]
+			switch name {
+			case includeKey:
+			case defaultsKey:
+				if defaultsFrom != "" {
+					return nil, inFile(src.path, at(doc.Content[i], fmt.Errorf("defaults are already set in %s", defaultsFrom)))
+				}
+				if err := value.Decode(&defaults); err != nil {
+					return nil, inFile(src.path, fmt.Errorf("%s: %w", defaultsKey, err))
+				}
+				defaultsFrom = src.path
+			case templatesKey:
+				var defined Templates
+				if err := value.Decode(&defined); err != nil {
+					return nil, inFile(src.path, fmt.Errorf("%s: %w", templatesKey, err))
+				}
+				for name, node := range defined {
+					if from, ok := templatesFrom[name]; ok {
+						return nil, inFile(src.path, at(node, fmt.Errorf("template %s is already defined in %s", name, from)))
+					}
+					templates[name], templatesFrom[name] = node, src.path
+				}
+			default:
+				if from, ok := pkgsFrom[name]; ok {
+					return nil, inFile(src.path, at(doc.Content[i], fmt.Errorf("package %s is already defined in %s", name, from)))
+				}
+				pkgs = append(pkgs, pkgNode{name: name, file: src.path, node: value})
+				pkgsFrom[name] = src.path
+			}
+		}
 	}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	cfg := make(Config)
+	for _, p := range pkgs {
+		name, file := p.name, p.file
+		var pkg PackageConfig
+		if err := p.node.Decode(&pkg); err != nil {
+			return nil, inFile(file, fmt.Errorf("package %s: %w", name, err))
+		}
+		pkg.setFile(file)
+		if err := pkg.useTemplates(templates); err != nil {
+			return nil, inFile(file, fmt.Errorf("package %s: %w", name, err))
+		}
+		if err := pkg.interpolate(); err != nil {
+			return nil, inFile(file, fmt.Errorf("package %s: %w", name, err))
+		}
+		if err := pkg.checkSteps(); err != nil {
+			return nil, inFile(file, fmt.Errorf("package %s: %w", name, err))
+		}
+		if err := pkg.checkTemplates(); err != nil {
+			return nil, inFile(file, fmt.Errorf("package %s: %w", name, err))
+		}
+		if err := pkg.resolveUsers(); err != nil {
+			return nil, inFile(file, fmt.Errorf("package %s: %w", name, err))
+		}
+		if pkg.MaxParallel < 0 {
+			return nil, inFile(file, at(p.node, fmt.Errorf("package %s: max_parallel must not be negative", name)))
+		}
+		pkg.applyDefaults(defaults)
+		cfg[name] = pkg
 	}
 
 	if len(cfg) == 0 {
 		return nil, fmt.Errorf("config file is empty")
 	}
-
 	return cfg, nil
 }
 
-// GetRun returns the directory-to-commands mapping for a given package name.
-// Returns nil if the package is not configured.
-func (c Config) GetRun(packageName string) map[string][]Command {
+// Get returns the configuration for a given package name.
+// The boolean is false if the package is not configured.
+func (c Config) Get(packageName string) (PackageConfig, bool) {
 	pkg, ok := c[packageName]
-	if !ok {
-		return nil
-	}
-	return pkg.Run
+	return pkg, ok
+}
+
+// GetRun returns the configuration to run for a package and tag, using the
+// tag's own run block when it has one. The boolean is false if the package
+// doesn't react to the tag. Unconfigured packages react only to "latest",
+// with nothing to run.
+func (c Config) GetRun(packageName, tag string) (PackageConfig, bool) {
+	return c[packageName].ForTag(tag)
 }
@@ -1,10 +1,16 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/jc/steakpie/internal/event"
 )
 
 func TestLoad_ValidYAML(t *testing.T) {
@@ -36,11 +42,11 @@ hello-world:
 	}
 
 	// Check jamiec package
-	jamiecRun := cfg.GetRun("jamiec")
+	jamiecRun := cfg["jamiec"].Run
 	if jamiecRun == nil {
 		t.Fatal("expected jamiec to have run config")
 	}
-	commands := jamiecRun["/opt/jamiec"]
+	commands := jamiecRun.Commands("/opt/jamiec")
 	if len(commands) != 2 {
 		t.Errorf("expected 2 commands for jamiec, got %d", len(commands))
 	}
@@ -49,11 +55,11 @@ hello-world:
 	}
 
 	// Check hello-world package
-	helloRun := cfg.GetRun("hello-world")
+	helloRun := cfg["hello-world"].Run
 	if helloRun == nil {
 		t.Fatal("expected hello-world to have run config")
 	}
-	helloCommands := helloRun["/opt/hello"]
+	helloCommands := helloRun.Commands("/opt/hello")
 	if len(helloCommands) != 1 {
 		t.Errorf("expected 1 command for hello-world, got %d", len(helloCommands))
 	}
@@ -78,7 +84,7 @@ func TestLoad_NestedConfig(t *testing.T) {
 		t.Fatalf("expected no error, got: %v", err)
 	}
 
-	commands := cfg.GetRun("mypackage")["/opt/mypackage"]
+	commands := cfg["mypackage"].Run.Commands("/opt/mypackage")
 	if len(commands) != 1 {
 		t.Fatalf("expected 1 top-level command, got %d", len(commands))
 	}
@@ -115,7 +121,7 @@ func TestLoad_MixedConfig(t *testing.T) {
 		t.Fatalf("expected no error, got: %v", err)
 	}
 
-	commands := cfg.GetRun("mixed")["/opt/mixed"]
+	commands := cfg["mixed"].Run.Commands("/opt/mixed")
 	if len(commands) != 2 {
 		t.Fatalf("expected 2 top-level commands, got %d", len(commands))
 	}
@@ -160,7 +166,7 @@ func TestLoad_DeeplyNestedConfig(t *testing.T) {
 		t.Fatalf("expected no error, got: %v", err)
 	}
 
-	commands := cfg.GetRun("deep")["/opt/deep"]
+	commands := cfg["deep"].Run.Commands("/opt/deep")
 	if len(commands) != 1 {
 		t.Fatalf("expected 1 top-level command, got %d", len(commands))
 	}
@@ -284,67 +290,1634 @@ func TestLoad_MultipleDirectories(t *testing.T) {
 		t.Fatalf("expected no error, got: %v", err)
 	}
 
-	run := cfg.GetRun("mypackage")
+	run := cfg["mypackage"].Run
 	if len(run) != 2 {
 		t.Fatalf("expected 2 directories, got %d", len(run))
 	}
 
-	frontend := run["/opt/frontend"]
+	frontend := run.Commands("/opt/frontend")
 	if len(frontend) != 1 || frontend[0].Cmd != "npm run build" {
 		t.Errorf("expected frontend command 'npm run build', got %v", frontend)
 	}
 
-	backend := run["/opt/backend"]
+	backend := run.Commands("/opt/backend")
 	if len(backend) != 1 || backend[0].Cmd != "go build ./..." {
 		t.Errorf("expected backend command 'go build ./...', got %v", backend)
 	}
 }
 
-func TestGetRun_ExistingPackage(t *testing.T) {
-	cfg := Config{
-		"test-package": {
-			Run: map[string][]Command{
-				"/opt/test": {
-					{Cmd: "echo test"},
-					{Cmd: "docker compose up"},
-				},
-			},
-		},
-		"other-package": {
-			Run: map[string][]Command{
-				"/opt/other": {
-					{Cmd: "echo other"},
-				},
-			},
-		},
+func TestLoad_SetupAndTeardown(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  setup:
+    /opt/db:
+      - ./snapshot.sh
+  run:
+    /opt/app:
+      - - docker compose pull
+        - docker compose up -d
+  teardown:
+    /opt/app:
+      - rm -f docker-compose.override.yml
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
 	}
 
-	run := cfg.GetRun("test-package")
-	if run == nil {
-		t.Fatal("expected run config for test-package")
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
 	}
-	commands := run["/opt/test"]
-	if len(commands) != 2 {
-		t.Errorf("expected 2 commands, got %d", len(commands))
+
+	pkg, ok := cfg.Get("mypackage")
+	if !ok {
+		t.Fatal("expected mypackage to be configured")
 	}
-	if commands[0].Cmd != "echo test" {
-		t.Errorf("expected first command to be 'echo test', got '%s'", commands[0].Cmd)
+
+	setup := pkg.Setup.Commands("/opt/db")
+	if len(setup) != 1 || setup[0].Cmd != "./snapshot.sh" {
+		t.Errorf("expected setup command './snapshot.sh', got %v", setup)
+	}
+
+	run := pkg.Run.Commands("/opt/app")
+	if len(run) != 1 || len(run[0].Children) != 1 {
+		t.Errorf("expected nested run command, got %v", run)
+	}
+
+	teardown := pkg.Teardown.Commands("/opt/app")
+	if len(teardown) != 1 || teardown[0].Cmd != "rm -f docker-compose.override.yml" {
+		t.Errorf("expected teardown command, got %v", teardown)
 	}
 }
 
-func TestGetRun_NonExistingPackage(t *testing.T) {
-	cfg := Config{
-		"test-package": {
-			Run: map[string][]Command{
-				"/opt/test": {
-					{Cmd: "echo test"},
-				},
-			},
-		},
+func TestLoad_MaxParallel(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  max_parallel: 2
+  run:
+    /opt/app:
+      - echo hello
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if cfg["mypackage"].MaxParallel != 2 {
+		t.Errorf("expected max_parallel 2, got %d", cfg["mypackage"].MaxParallel)
+	}
+}
+
+func TestLoad_NegativeMaxParallel(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  max_parallel: -1
+  run:
+    /opt/app:
+      - echo hello
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	_, err := Load(configPath)
+	if err == nil {
+		t.Fatal("expected error for negative max_parallel, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "max_parallel must not be negative") {
+		t.Errorf("error should mention max_parallel, got: %v", err)
+	}
+}
+
+func TestLoad_DirectoryOrderPreserved(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  run:
+    /srv/e:
+      - echo e
+    /srv/a:
+      - echo a
+    /srv/d:
+      - echo d
+    /srv/b:
+      - echo b
+    /srv/c:
+      - echo c
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	expected := []string{"/srv/e", "/srv/a", "/srv/d", "/srv/b", "/srv/c"}
+	run := cfg["mypackage"].Run
+	if len(run) != len(expected) {
+		t.Fatalf("expected %d directories, got %d", len(expected), len(run))
+	}
+	for i, dir := range expected {
+		if run[i].Dir != dir {
+			t.Errorf("directory %d: expected '%s', got '%s'", i, dir, run[i].Dir)
+		}
+	}
+}
+
+func TestLoad_DirectoryListForm(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  run:
+    - dir: /srv/api
+      commands:
+        - ./migrate
+    - dir: /srv/web
+      needs: /srv/api
+      commands:
+        - - docker compose pull
+          - docker compose up -d
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	run := cfg["mypackage"].Run
+	if len(run) != 2 {
+		t.Fatalf("expected 2 directories, got %d", len(run))
+	}
+	if run[0].Dir != "/srv/api" || run[1].Dir != "/srv/web" {
+		t.Errorf("expected /srv/api then /srv/web, got %s then %s", run[0].Dir, run[1].Dir)
+	}
+	if len(run[1].Needs) != 1 || run[1].Needs[0] != "/srv/api" {
+		t.Errorf("expected /srv/web to need /srv/api, got %v", run[1].Needs)
+	}
+	if len(run[1].Commands) != 1 || len(run[1].Commands[0].Children) != 1 {
+		t.Errorf("expected nested command in /srv/web, got %v", run[1].Commands)
+	}
+}
+
+func TestLoad_DirectoryMappingWithNeeds(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  run:
+    /srv/api:
+      - ./migrate
+    /srv/worker:
+      - ./drain
+    /srv/web:
+      needs: [/srv/api, /srv/worker]
+      commands:
+        - docker compose up -d
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	web := cfg["mypackage"].Run[2]
+	if web.Dir != "/srv/web" || len(web.Needs) != 2 {
+		t.Errorf("expected /srv/web with 2 needs, got %+v", web)
+	}
+	if len(web.Commands) != 1 || web.Commands[0].Cmd != "docker compose up -d" {
+		t.Errorf("expected /srv/web command, got %v", web.Commands)
+	}
+}
+
+func TestLoad_DirectoryNeedsMustComeFirst(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  run:
+    - dir: /srv/web
+      needs: /srv/api
+      commands: [docker compose up -d]
+    - dir: /srv/api
+      commands: [./migrate]
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	_, err := Load(configPath)
+	if err == nil {
+		t.Fatal("expected error for forward needs reference, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "must be listed before it") {
+		t.Errorf("error should explain needs ordering, got: %v", err)
+	}
+}
+
+func TestLoad_DuplicateDirectoryInList(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  run:
+    - dir: /srv/api
+      commands: [./migrate]
+    - dir: /srv/api
+      commands: [./seed]
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	_, err := Load(configPath)
+	if err == nil {
+		t.Fatal("expected error for duplicate directory, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "listed more than once") {
+		t.Errorf("error should mention duplicate directory, got: %v", err)
+	}
+}
+
+func TestLoad_Timeouts(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `defaults:
+  timeout: 10m
+  idle_timeout: 120
+
+mypackage:
+  run:
+    /opt/app:
+      timeout: 2m
+      commands:
+        - cmd: docker compose pull
+          timeout: 5m
+          idle_timeout: 45s
+          children:
+            - docker compose up -d
+    /opt/other:
+      - echo other
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if _, ok := cfg.Get("defaults"); ok {
+		t.Error("expected defaults not to be treated as a package")
+	}
+
+	run := cfg["mypackage"].Run
+	app, other := run[0], run[1]
+
+	if app.Timeout != 2*time.Minute {
+		t.Errorf("expected directory timeout 2m, got %s", app.Timeout)
+	}
+	if app.IdleTimeout != 2*time.Minute {
+		t.Errorf("expected directory idle timeout inherited as 2m, got %s", app.IdleTimeout)
+	}
+	if other.Timeout != 10*time.Minute {
+		t.Errorf("expected default timeout 10m, got %s", other.Timeout)
+	}
+
+	pull := app.Commands[0]
+	if pull.Cmd != "docker compose pull" {
+		t.Errorf("expected 'docker compose pull', got '%s'", pull.Cmd)
+	}
+	if pull.Timeout != 5*time.Minute || pull.IdleTimeout != 45*time.Second {
+		t.Errorf("expected command timeouts 5m/45s, got %s/%s", pull.Timeout, pull.IdleTimeout)
+	}
+	if len(pull.Children) != 1 || pull.Children[0].Cmd != "docker compose up -d" {
+		t.Errorf("expected child 'docker compose up -d', got %v", pull.Children)
+	}
+}
+
+func TestLoad_InvalidTimeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  run:
+    /opt/app:
+      - cmd: sleep 10
+        timeout: soon
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	_, err := Load(configPath)
+	if err == nil {
+		t.Fatal("expected error for invalid timeout, got nil")
+	}
+
+	if !strings.Contains(err.Error(), `invalid duration "soon"`) {
+		t.Errorf("error should mention the invalid duration, got: %v", err)
+	}
+}
+
+func TestLoad_CommandMappingMissingCmd(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  run:
+    /opt/app:
+      - timeout: 5m
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	_, err := Load(configPath)
+	if err == nil {
+		t.Fatal("expected error for command mapping without cmd, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "missing cmd") {
+		t.Errorf("error should mention the missing cmd, got: %v", err)
+	}
+}
+
+func TestLoad_Retries(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  run:
+    /opt/app:
+      - cmd: docker pull ghcr.io/example/app:latest
+        retries: 4
+        backoff: 3s
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
 	}
 
-	run := cfg.GetRun("nonexistent")
-	if run != nil {
-		t.Errorf("expected nil for non-existing package, got %v", run)
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	cmd := cfg["mypackage"].Run.Commands("/opt/app")[0]
+	if cmd.Retries != 4 {
+		t.Errorf("expected 4 retries, got %d", cmd.Retries)
+	}
+	if cmd.Backoff != 3*time.Second {
+		t.Errorf("expected backoff 3s, got %s", cmd.Backoff)
+	}
+}
+
+func TestLoad_NegativeRetries(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  run:
+    /opt/app:
+      - cmd: docker pull
+        retries: -1
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	_, err := Load(configPath)
+	if err == nil {
+		t.Fatal("expected error for negative retries, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "retries: must not be negative") {
+		t.Errorf("error should mention retries, got: %v", err)
+	}
+}
+
+func TestLoad_TooManyRetries(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  run:
+    /opt/app:
+      - cmd: docker pull
+        retries: 30
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	_, err := Load(configPath)
+	if err == nil {
+		t.Fatal("expected error for 30 retries, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "retries: must be at most 20") {
+		t.Errorf("error should mention retries, got: %v", err)
+	}
+}
+
+func TestLoad_Tags(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  tags:
+    - main
+    - sha-*
+    - ">=1.4.0 <2"
+  run:
+    /opt/app:
+      - docker compose up -d
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	pkg := cfg["mypackage"]
+	for tag, want := range map[string]bool{
+		"main":       true,
+		"sha-abc123": true,
+		"v1.4.2":     true,
+		"v2.0.0":     false,
+		"latest":     false,
+	} {
+		if got := pkg.MatchTag(tag); got != want {
+			t.Errorf("tag %q: expected match %v, got %v", tag, want, got)
+		}
+	}
+}
+
+func TestLoad_InvalidTagPattern(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  tags:
+    - /[unclosed/
+  run:
+    /opt/app:
+      - echo hello
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	_, err := Load(configPath)
+	if err == nil {
+		t.Fatal("expected error for invalid tag pattern, got nil")
+	}
+
+	if !strings.Contains(err.Error(), "invalid tag regex") {
+		t.Errorf("error should mention the invalid regex, got: %v", err)
+	}
+}
+
+func TestGetRun_ExistingPackage(t *testing.T) {
+	cfg := Config{
+		"test-package": {
+			Run: Directories{
+				{Dir: "/opt/test", Commands: []Command{
+					{Cmd: "echo test"},
+					{Cmd: "docker compose up"},
+				}},
+			},
+		},
+		"other-package": {
+			Run: Directories{
+				{Dir: "/opt/other", Commands: []Command{
+					{Cmd: "echo other"},
+				}},
+			},
+		},
+	}
+
+	pkg, ok := cfg.GetRun("test-package", "latest")
+	if !ok {
+		t.Fatal("expected run config for test-package")
+	}
+	commands := pkg.Run.Commands("/opt/test")
+	if len(commands) != 2 {
+		t.Errorf("expected 2 commands, got %d", len(commands))
+	}
+	if commands[0].Cmd != "echo test" {
+		t.Errorf("expected first command to be 'echo test', got '%s'", commands[0].Cmd)
+	}
+}
+
+func TestGetRun_NonExistingPackage(t *testing.T) {
+	cfg := Config{
+		"test-package": {
+			Run: Directories{
+				{Dir: "/opt/test", Commands: []Command{
+					{Cmd: "echo test"},
+				}},
+			},
+		},
+	}
+
+	pkg, _ := cfg.GetRun("nonexistent", "latest")
+	if pkg.HasCommands() {
+		t.Errorf("expected nothing to run for non-existing package, got %v", pkg)
+	}
+}
+
+func TestGetRun_PerTagBlocks(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `mypackage:
+  max_parallel: 3
+  teardown:
+    /tmp:
+      - rm -f override.yml
+  tags:
+    staging:
+      run:
+        /srv/staging:
+          - docker compose up -d
+    latest:
+      setup:
+        /srv/prod:
+          - ./snapshot.sh
+      run:
+        /srv/prod:
+          - docker compose up -d
+    main:
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	staging, ok := cfg.GetRun("mypackage", "staging")
+	if !ok {
+		t.Fatal("expected staging tag to match")
+	}
+	if len(staging.Run) != 1 || staging.Run[0].Dir != "/srv/staging" {
+		t.Errorf("expected staging to run /srv/staging, got %v", staging.Run)
+	}
+	if len(staging.Setup) != 0 || len(staging.Teardown) != 0 {
+		t.Errorf("expected staging block to replace setup and teardown, got %v / %v", staging.Setup, staging.Teardown)
+	}
+	if staging.MaxParallel != 3 {
+		t.Errorf("expected package settings to carry over, got max_parallel %d", staging.MaxParallel)
+	}
+
+	prod, ok := cfg.GetRun("mypackage", "latest")
+	if !ok {
+		t.Fatal("expected latest tag to match")
+	}
+	if len(prod.Run) != 1 || prod.Run[0].Dir != "/srv/prod" || len(prod.Setup) != 1 {
+		t.Errorf("expected latest to run /srv/prod with setup, got %v / %v", prod.Run, prod.Setup)
+	}
+
+	main, ok := cfg.GetRun("mypackage", "main")
+	if !ok {
+		t.Fatal("expected main tag to match")
+	}
+	if len(main.Teardown) != 1 || main.Teardown[0].Dir != "/tmp" {
+		t.Errorf("expected main to use the package's own phases, got %v", main.Teardown)
+	}
+
+	if _, ok := cfg.GetRun("mypackage", "v1.0.0"); ok {
+		t.Error("expected unlisted tag not to match")
+	}
+}
+
+func TestGetRun_FirstMatchingTagWins(t *testing.T) {
+	mustPattern := func(s string) TagPattern {
+		p, err := ParseTagPattern(s)
+		if err != nil {
+			t.Fatalf("invalid pattern %q: %v", s, err)
+		}
+		return p
+	}
+	cfg := Config{
+		"mypackage": {
+			Tags: TagRules{
+				{Pattern: mustPattern("v1.*"), Override: true, Run: Directories{{Dir: "/srv/v1"}}},
+				{Pattern: mustPattern("v*"), Override: true, Run: Directories{{Dir: "/srv/any"}}},
+			},
+		},
+	}
+
+	pkg, ok := cfg.GetRun("mypackage", "v1.2.0")
+	if !ok || pkg.Run[0].Dir != "/srv/v1" {
+		t.Errorf("expected first matching rule to win, got %v", pkg.Run)
+	}
+
+	pkg, ok = cfg.GetRun("mypackage", "v2.0.0")
+	if !ok || pkg.Run[0].Dir != "/srv/any" {
+		t.Errorf("expected second rule for v2.0.0, got %v", pkg.Run)
+	}
+}
+
+func TestLoad_TagBlockDefaultsApplied(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `defaults:
+  timeout: 7m
+
+mypackage:
+  tags:
+    staging:
+      run:
+        /srv/staging:
+          - docker compose up -d
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	pkg, _ := cfg.GetRun("mypackage", "staging")
+	if pkg.Run[0].Timeout != 7*time.Minute {
+		t.Errorf("expected default timeout in tag block, got %s", pkg.Run[0].Timeout)
+	}
+}
+
+func TestLoad_Interpolation(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	secretPath := filepath.Join(tmpDir, "token")
+	if err := os.WriteFile(secretPath, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+
+	t.Setenv("APP_ROOT", "/srv/app")
+	t.Setenv("EMPTY", "")
+
+	content := `mypackage:
+  run:
+    ${APP_ROOT}/api:
+      - - echo ${EMPTY:-fallback} ${MISSING:-default}
+        - deploy --token ${file:` + secretPath + `}
+    /srv/web:
+      needs: ${APP_ROOT}/api
+      commands:
+        - echo $${HOME} ${EMPTY}
+        - docker pull app@${STEAKPIE_SHA}
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	run := cfg["mypackage"].Run
+	if run[0].Dir != "/srv/app/api" {
+		t.Errorf("expected directory key to be expanded, got %q", run[0].Dir)
+	}
+	cmd := run[0].Commands[0]
+	if cmd.Cmd != "echo fallback default" {
+		t.Errorf("expected defaults to be used, got %q", cmd.Cmd)
+	}
+	if cmd.Children[0].Cmd != "deploy --token s3cret" {
+		t.Errorf("expected file contents without trailing newline, got %q", cmd.Children[0].Cmd)
+	}
+	if shown := cmd.Children[0].Shown(); shown != "deploy --token ${file:"+secretPath+"}" {
+		t.Errorf("expected the command to be shown as written, got %q", shown)
+	}
+	if len(run[1].Needs) != 1 || run[1].Needs[0] != "/srv/app/api" {
+		t.Errorf("expected needs to be expanded, got %v", run[1].Needs)
+	}
+	if run[1].Commands[0].Cmd != "echo ${HOME} " {
+		t.Errorf("expected $${ to be left for the shell, got %q", run[1].Commands[0].Cmd)
+	}
+	if run[1].Commands[1].Cmd != "docker pull app@${STEAKPIE_SHA}" {
+		t.Errorf("expected STEAKPIE_ variables to be left for the shell, got %q", run[1].Commands[1].Cmd)
+	}
+	if run[1].Commands[1].Raw != "" {
+		t.Errorf("expected no separate text for a command expansion left alone, got %q", run[1].Commands[1].Raw)
+	}
+}
+
+func TestLoad_InterpolatedValuesAreNotTemplates(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	secretPath := filepath.Join(tmpDir, "token")
+	if err := os.WriteFile(secretPath, []byte("s3cr{{et\n"), 0600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+
+	t.Setenv("TOK", "ab{{.Tag}}cd")
+
+	content := `mypackage:
+  run:
+    /srv/app:
+      - echo ${TOK} {{.Tag}}
+      - deploy --token ${file:` + secretPath + `}
+      - argv: [deploy, "${TOK}", "{{.Tag}}"]
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	ev := event.Event{Tag: "v1"}
+	cmds := cfg["mypackage"].Run[0].Commands
+	for i, want := range []string{"echo ab{{.Tag}}cd v1", "deploy --token s3cr{{et"} {
+		if got, err := ev.Render(cmds[i].Cmd); err != nil || got != want {
+			t.Errorf("expected command %d to render to %q, got %q, %v", i+1, want, got, err)
+		}
+	}
+	var argv []string
+	for _, arg := range cmds[2].Argv {
+		rendered, err := ev.Render(arg)
+		if err != nil {
+			t.Fatalf("expected argument %q to render, got: %v", arg, err)
+		}
+		argv = append(argv, rendered)
+	}
+	if want := []string{"deploy", "ab{{.Tag}}cd", "v1"}; !slices.Equal(argv, want) {
+		t.Errorf("expected argv %q, got %q", want, argv)
+	}
+}
+
+func TestLoad_InterpolationErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr []string
+	}{
+		{
+			name:    "missing variable in command",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - echo ${DEPLOY_TEST_UNSET}\n",
+			wantErr: []string{"package mypackage", "directory /srv/app", "DEPLOY_TEST_UNSET is not set"},
+		},
+		{
+			name:    "missing variable in directory key",
+			content: "mypackage:\n  run:\n    ${DEPLOY_TEST_UNSET}/app:\n      - echo hi\n",
+			wantErr: []string{"package mypackage", "directory ${DEPLOY_TEST_UNSET}/app", "DEPLOY_TEST_UNSET is not set"},
+		},
+		{
+			name:    "missing file",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - echo ${file:/nonexistent/secret}\n",
+			wantErr: []string{"package mypackage", "directory /srv/app", "/nonexistent/secret"},
+		},
+		{
+			name:    "shell syntax",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - echo ${#list}\n",
+			wantErr: []string{"invalid variable reference ${#list}"},
+		},
+		{
+			name:    "webhook secret",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - echo ${WEBHOOK_SECRET}\n",
+			wantErr: []string{"${WEBHOOK_SECRET} is never passed on to commands"},
+		},
+		{
+			name:    "unterminated",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - echo ${HOME\n",
+			wantErr: []string{"unterminated ${"},
+		},
+		{
+			name:    "directories collide after expansion",
+			content: "mypackage:\n  run:\n    ${DEPLOY_TEST_DIR}:\n      - echo a\n    /srv/app:\n      - echo b\n",
+			wantErr: []string{"directory /srv/app is listed more than once"},
+		},
+	}
+
+	t.Setenv("DEPLOY_TEST_DIR", "/srv/app")
+	os.Unsetenv("DEPLOY_TEST_UNSET")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write test config: %v", err)
+			}
+
+			_, err := Load(configPath)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to contain %q, got: %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad_CommandTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     string
+		wantErr string
+	}{
+		{name: "valid", cmd: `docker pull app:{{ .Tag | trimPrefix "v" }}`},
+		{name: "unknown field", cmd: "docker pull app:{{ .Tags }}", wantErr: "can't evaluate field Tags"},
+		{name: "syntax error", cmd: "docker pull app:{{ .Tag", wantErr: "unclosed action"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			content := "mypackage:\n  run:\n    /srv/app:\n      - - echo parent\n        - '" + tt.cmd + "'\n"
+			if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+				t.Fatalf("failed to write test config: %v", err)
+			}
+
+			_, err := Load(configPath)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			for _, want := range []string{"package mypackage", "directory /srv/app", tt.wantErr} {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to contain %q, got: %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad_Shell(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `defaults:
+  shell: sh
+
+inherits:
+  run:
+    /srv/a:
+      - echo a
+bash:
+  shell: bash
+  run:
+    /srv/b:
+      - echo b
+python:
+  shell: python3 -c
+  run:
+    /srv/c:
+      - print("c")
+nu:
+  shell: [nu, --login, -c]
+  run:
+    /srv/d:
+      - echo d
+fish:
+  shell: fish
+  run:
+    /srv/e:
+      - echo e
+direct:
+  shell: none
+  run:
+    /srv/f:
+      - echo f
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	expected := map[string]string{
+		"inherits": "sh -lc",
+		"bash":     "bash -lc",
+		"python":   "python3 -c",
+		"nu":       "nu --login -c",
+		"fish":     "fish -c",
+		"direct":   "none",
+	}
+	for name, want := range expected {
+		if got := strings.Join(cfg[name].Shell, " "); got != want {
+			t.Errorf("package %s: expected shell %q, got %q", name, want, got)
+		}
+	}
+	if !cfg["direct"].Shell.None() {
+		t.Error("expected shell none to run without a shell")
+	}
+	if cfg["bash"].Shell.None() {
+		t.Error("expected bash to run with a shell")
+	}
+}
+
+func TestLoad_ArgvCommand(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	t.Setenv("PROJECT", "jamiec")
+
+	content := `mypackage:
+  run:
+    /srv/app:
+      - argv: [docker, compose, -p, "${PROJECT}", up, -d]
+        children:
+          - echo done
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	cmd := cfg["mypackage"].Run[0].Commands[0]
+	want := []string{"docker", "compose", "-p", "jamiec", "up", "-d"}
+	if strings.Join(cmd.Argv, "|") != strings.Join(want, "|") {
+		t.Errorf("expected argv %q, got %q", want, cmd.Argv)
+	}
+	if cmd.Cmd != "docker compose -p jamiec up -d" {
+		t.Errorf("expected Cmd to describe the argv, got %q", cmd.Cmd)
+	}
+	if len(cmd.Children) != 1 || cmd.Children[0].Argv != nil {
+		t.Errorf("expected a plain child command, got %+v", cmd.Children)
+	}
+}
+
+func TestLoad_InvalidShellAndArgv(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"empty shell", "mypackage:\n  shell: \"\"\n  run:\n    /srv/app:\n      - echo hi\n", "shell must not be empty"},
+		{"shell mapping", "mypackage:\n  shell: {name: sh}\n  run:\n    /srv/app:\n      - echo hi\n", "shell must be a string or a list of strings"},
+		{"cmd and argv", "mypackage:\n  run:\n    /srv/app:\n      - cmd: echo hi\n        argv: [echo, hi]\n", "either cmd or argv, not both"},
+		{"empty argv", "mypackage:\n  run:\n    /srv/app:\n      - argv: []\n", "argv: must not be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write test config: %v", err)
+			}
+
+			_, err := Load(configPath)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error to contain %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_Env(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	t.Setenv("STACK", "prod")
+
+	content := `mypackage:
+  clean_env: true
+  allow_env: [PATH, HOME]
+  env:
+    COMPOSE_PROJECT_NAME: ${STACK}
+    REPLICAS: 3
+  env_file: /etc/steakpie/common.env
+  run:
+    /srv/app:
+      env_file: [.env, ../shared.env]
+      env:
+        PORT: "8080"
+      commands:
+        - cmd: docker compose up -d
+          env:
+            DEBUG: "1"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	pkg := cfg["mypackage"]
+	if !pkg.CleanEnv || strings.Join(pkg.AllowEnv, ",") != "PATH,HOME" {
+		t.Errorf("expected clean env allowing PATH and HOME, got %v %v", pkg.CleanEnv, pkg.AllowEnv)
+	}
+	if pkg.Env.Vars["COMPOSE_PROJECT_NAME"] != "prod" || pkg.Env.Vars["REPLICAS"] != "3" {
+		t.Errorf("unexpected package env: %v", pkg.Env.Vars)
+	}
+	if strings.Join(pkg.Env.Files, ",") != "/etc/steakpie/common.env" {
+		t.Errorf("unexpected package env files: %v", pkg.Env.Files)
+	}
+
+	dir := pkg.Run[0]
+	if dir.Env.Vars["PORT"] != "8080" || strings.Join(dir.Env.Files, ",") != ".env,../shared.env" {
+		t.Errorf("unexpected directory env: %+v", dir.Env)
+	}
+	if cmd := dir.Commands[0]; cmd.Env.Vars["DEBUG"] != "1" {
+		t.Errorf("unexpected command env: %+v", cmd.Env)
+	}
+}
+
+func TestLoad_InvalidEnv(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := "mypackage:\n  run:\n    /srv/app:\n      env: [A=1]\n      commands:\n        - echo hi\n"
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	_, err := Load(configPath)
+	if err == nil {
+		t.Fatal("expected error for env given as a list, got nil")
+	}
+	if !strings.Contains(err.Error(), "expected a mapping of variable names to values") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLoad_FailureAndAlwaysBranches(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	t.Setenv("PREVIOUS", "app:1.2.3")
+
+	content := `mypackage:
+  run:
+    /srv/app:
+      - cmd: docker compose up -d
+        children:
+          - ./smoke-test.sh
+        on_failure:
+          - docker compose down
+          - - docker tag ${PREVIOUS} app:latest
+            - docker compose up -d
+        always:
+          - ./send-logs.sh {{.Tag}}
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	cmd := cfg["mypackage"].Run[0].Commands[0]
+	if len(cmd.Children) != 1 || cmd.Children[0].Cmd != "./smoke-test.sh" {
+		t.Errorf("unexpected children: %+v", cmd.Children)
+	}
+	if len(cmd.OnFailure) != 2 || cmd.OnFailure[0].Cmd != "docker compose down" {
+		t.Fatalf("unexpected on_failure: %+v", cmd.OnFailure)
+	}
+	if rollback := cmd.OnFailure[1]; rollback.Cmd != "docker tag app:1.2.3 app:latest" || len(rollback.Children) != 1 {
+		t.Errorf("expected nested, interpolated on_failure commands, got %+v", rollback)
+	}
+	if len(cmd.Always) != 1 || cmd.Always[0].Cmd != "./send-logs.sh {{.Tag}}" {
+		t.Errorf("unexpected always: %+v", cmd.Always)
+	}
+}
+
+func TestLoad_BranchTemplateChecked(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := "mypackage:\n  run:\n    /srv/app:\n      - cmd: deploy\n        on_failure:\n          - rollback {{.Nope}}\n"
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	_, err := Load(configPath)
+	if err == nil || !strings.Contains(err.Error(), "can't evaluate field Nope") {
+		t.Errorf("expected the on_failure template to be checked, got: %v", err)
+	}
+}
+
+func TestLoad_SuccessConditions(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := `mypackage:
+  run:
+    /srv/app:
+      - cmd: ./migrate
+        success_exit_codes: [0, 1]
+        fail_if_output_matches: (?i)error
+        succeed_if_output_matches: nothing to do
+      - cmd: ./check
+        success_exit_codes: 2
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	cmds := cfg["mypackage"].Run[0].Commands
+	if len(cmds[0].SuccessExitCodes) != 2 || cmds[0].SuccessExitCodes[1] != 1 {
+		t.Errorf("unexpected success_exit_codes: %v", cmds[0].SuccessExitCodes)
+	}
+	if re := cmds[0].FailIfOutputMatches; re == nil || !re.MatchString("Error: boom") {
+		t.Errorf("unexpected fail_if_output_matches: %v", re)
+	}
+	if re := cmds[0].SucceedIfOutputMatches; re == nil || re.String() != "nothing to do" {
+		t.Errorf("unexpected succeed_if_output_matches: %v", re)
+	}
+	if len(cmds[1].SuccessExitCodes) != 1 || cmds[1].SuccessExitCodes[0] != 2 {
+		t.Errorf("expected a single exit code to be accepted, got %v", cmds[1].SuccessExitCodes)
+	}
+}
+
+func TestLoad_InvalidSuccessConditions(t *testing.T) {
+	tests := []struct {
+		name    string
+		setting string
+		wantErr string
+	}{
+		{"exit code out of range", "success_exit_codes: [0, 256]", "success_exit_codes: exit code 256 is out of range 0-255"},
+		{"empty exit codes", "success_exit_codes: []", "success_exit_codes: must not be empty"},
+		{"invalid regex", "fail_if_output_matches: \"(unclosed\"", "fail_if_output_matches: invalid regex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			content := "mypackage:\n  run:\n    /srv/app:\n      - cmd: ./migrate\n        " + tt.setting + "\n"
+			if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+				t.Fatalf("failed to write test config: %v", err)
+			}
+
+			_, err := Load(configPath)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error to contain %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_Capture(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := `mypackage:
+  run:
+    /srv/app:
+      - cmd: docker inspect --format '{{"{{"}}.Image{{"}}"}}' app
+        capture: PREVIOUS_IMAGE
+      - cmd: docker compose up -d
+        on_failure:
+          - docker tag {{.Outputs.PREVIOUS_IMAGE}} app:latest
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if got := cfg["mypackage"].Run[0].Commands[0].Capture; got != "PREVIOUS_IMAGE" {
+		t.Errorf("expected capture PREVIOUS_IMAGE, got %q", got)
+	}
+}
+
+func TestLoad_CaptureErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "invalid name",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - cmd: date\n        capture: not-valid\n",
+			wantErr: `capture: "not-valid" is not a valid name`,
+		},
+		{
+			name:    "unknown output",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - echo {{.Outputs.NOPE}}\n",
+			wantErr: `map has no entry for key "NOPE"`,
+		},
+		{
+			name:    "output used before it is captured",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - echo {{.Outputs.LATER}}\n      - cmd: date\n        capture: LATER\n",
+			wantErr: `map has no entry for key "LATER"`,
+		},
+		{
+			name:    "output of another directory",
+			content: "mypackage:\n  run:\n    /srv/a:\n      - cmd: date\n        capture: A\n    /srv/b:\n      - echo {{.Outputs.A}}\n",
+			wantErr: `map has no entry for key "A"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write test config: %v", err)
+			}
+
+			_, err := Load(configPath)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error to contain %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_Steps(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := `mypackage:
+  run:
+    /srv/app:
+      - cmd: docker compose restart web
+        needs: [migrate, warm-cache]
+      - cmd: ./manage.py migrate
+        name: migrate
+        needs: pull
+      - cmd: ./warm-cache.sh {{.Outputs.IMAGE}}
+        name: warm-cache
+        needs: pull
+      - cmd: docker inspect --format '{{"{{"}}.Image{{"}}"}}' app
+        name: pull
+        capture: IMAGE
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	cmds := cfg["mypackage"].Run[0].Commands
+	if !slices.Equal(cmds[0].Needs, []string{"migrate", "warm-cache"}) {
+		t.Errorf("expected needs [migrate warm-cache], got %v", cmds[0].Needs)
+	}
+	if cmds[1].Name != "migrate" || !slices.Equal(cmds[1].Needs, []string{"pull"}) {
+		t.Errorf("expected step migrate needing pull, got name %q needs %v", cmds[1].Name, cmds[1].Needs)
+	}
+	if got := StepDeps(cmds, 0); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("expected the restart to wait for commands 1 and 2, got %v", got)
+	}
+}
+
+func TestLoad_StepErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "unknown step",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - cmd: date\n        needs: build\n",
+			wantErr: `command "date" needs build, which is not a step at the same level`,
+		},
+		{
+			name:    "step at another level",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - cmd: make\n        name: build\n        children:\n          - cmd: date\n            needs: build\n",
+			wantErr: "needs build, which is not a step at the same level",
+		},
+		{
+			name:    "duplicate name",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - cmd: make\n        name: build\n      - cmd: date\n        name: build\n",
+			wantErr: "step build is named more than once",
+		},
+		{
+			name:    "cycle",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - cmd: a\n        name: a\n        needs: c\n      - cmd: b\n        name: b\n        needs: a\n      - cmd: c\n        name: c\n        needs: b\n",
+			wantErr: "steps wait for each other in a cycle: a → c → b → a",
+		},
+		{
+			name:    "needs itself",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - cmd: a\n        name: a\n        needs: a\n",
+			wantErr: "cycle: a → a",
+		},
+		{
+			name:    "cycle through a capture",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - cmd: date\n        capture: NOW\n        needs: later\n      - cmd: echo\n        name: later\n",
+			wantErr: `cycle: "date" → later → "date"`,
+		},
+		{
+			name:    "output of a step it doesn't wait for",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - echo {{.Outputs.NOW}}\n      - cmd: date\n        name: now\n        capture: NOW\n",
+			wantErr: `map has no entry for key "NOW"`,
+		},
+		{
+			name:    "own output in always",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - cmd: date\n        capture: NOW\n        always:\n          - echo {{.Outputs.NOW}}\n",
+			wantErr: `map has no entry for key "NOW"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write test config: %v", err)
+			}
+
+			_, err := Load(configPath)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error to contain %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_Templates(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := `templates:
+  compose-redeploy:
+    cmd: docker compose pull ${with.service}
+    timeout: 5m
+    children:
+      - cmd: docker compose up -d ${with.service}
+        children:
+          - docker image prune -f ${with.prune_flags:-}
+  notify:
+    use: slack
+    with:
+      text: deployed ${with.what}
+  slack:
+    - echo "${with.text}" '$${with.text}'
+
+mypackage:
+  run:
+    /srv/app:
+      - docker compose config -q
+      - use: compose-redeploy
+        with:
+          service: web
+          prune_flags: --all
+        name: redeploy
+        needs: []
+      - cmd: ./smoke-test.sh
+        children:
+          - use: notify
+            with: {what: "${DEPLOY_TEST_TARGET}"}
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+	t.Setenv("DEPLOY_TEST_TARGET", "production")
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, ok := cfg[templatesKey]; ok {
+		t.Error("expected templates not to be treated as a package")
+	}
+
+	cmds := cfg["mypackage"].Run[0].Commands
+	redeploy := cmds[1]
+	if redeploy.Cmd != "docker compose pull web" || redeploy.Name != "redeploy" || redeploy.Timeout != 5*time.Minute {
+		t.Errorf("expected the template's command with the use entry's name, got %+v", redeploy)
+	}
+	up := redeploy.Children[0]
+	if up.Cmd != "docker compose up -d web" {
+		t.Errorf("expected parameters filled in for children, got %q", up.Cmd)
+	}
+	if got := up.Children[0].Cmd; got != "docker image prune -f --all" {
+		t.Errorf("expected optional parameter to be filled in, got %q", got)
+	}
+	if redeploy.Use != "" || redeploy.With != nil {
+		t.Errorf("expected use and with to be gone after expansion, got %q %v", redeploy.Use, redeploy.With)
+	}
+
+	notify := cmds[2].Children[0]
+	if want := `echo "deployed production" '${with.text}'`; notify.Cmd != want {
+		t.Errorf("expected nested template with interpolated parameters %q, got %q", want, notify.Cmd)
+	}
+}
+
+func TestLoad_TemplateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "unknown template",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - use: nope\n",
+			wantErr: "directory /srv/app: unknown template nope",
+		},
+		{
+			name:    "missing parameter",
+			content: "templates:\n  up: docker compose up -d ${with.service}\nmypackage:\n  run:\n    /srv/app:\n      - use: up\n",
+			wantErr: "template up: parameter service is not set",
+		},
+		{
+			name:    "unknown parameter",
+			content: "templates:\n  up: docker compose up -d\nmypackage:\n  run:\n    /srv/app:\n      - use: up\n        with: {service: web}\n",
+			wantErr: "template up has no parameter service",
+		},
+		{
+			name:    "template uses itself",
+			content: "templates:\n  a: {use: b}\n  b:\n    cmd: date\n    children: [{use: a}]\nmypackage:\n  run:\n    /srv/app:\n      - use: a\n",
+			wantErr: "template a: template b: template a uses itself",
+		},
+		{
+			name:    "other keys alongside use",
+			content: "templates:\n  up: docker compose up -d\nmypackage:\n  run:\n    /srv/app:\n      - use: up\n        timeout: 5m\n",
+			wantErr: "timeout can't be set alongside use",
+		},
+		{
+			name:    "with without use",
+			content: "mypackage:\n  run:\n    /srv/app:\n      - cmd: date\n        with: {a: b}\n",
+			wantErr: "with is only allowed alongside use",
+		},
+		{
+			name:    "invalid template",
+			content: "templates:\n  up:\n    cmd: date\n    timeout: ${with.timeout}\nmypackage:\n  run:\n    /srv/app:\n      - use: up\n        with: {timeout: soon}\n",
+			wantErr: `template up: timeout: invalid duration "soon"`,
+		},
+		{
+			name:    "templates not a mapping",
+			content: "templates: [a]\nmypackage:\n  run:\n    /srv/app:\n      - date\n",
+			wantErr: "templates must be a mapping",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write test config: %v", err)
+			}
+
+			_, err := Load(configPath)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error to contain %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_ErrorPositions(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		line, column int
+	}{
+		{"unknown command key", "app:\n  run:\n    /srv/app:\n      - cmd: date\n        tiemout: 5m\n", 5, 9},
+		{"invalid command value", "app:\n  run:\n    /srv/app:\n      - cmd: date\n        retries: -1\n", 5, 18},
+		{"empty command sequence", "app:\n  run:\n    /srv/app:\n      - []\n", 4, 9},
+		{"unknown directory key", "app:\n  run:\n    /srv/app:\n      commands: [date]\n      need: /srv/db\n", 5, 7},
+		{"unknown package key", "app:\n  max_paralel: 2\n", 2, 3},
+		{"unknown defaults key", "defaults:\n  timout: 5m\napp: {}\n", 2, 3},
+		{"invalid tag pattern", "app:\n  tags:\n    - \"/[/\"\n", 3, 7},
+		{"unknown template field", "app:\n  run:\n    /srv/app:\n      - date\n      - echo {{.Nope}}\n", 5, 9},
+		{"unknown step", "app:\n  run:\n    /srv/app:\n      - date\n      - {cmd: date, needs: build}\n", 5, 9},
+		{"step cycle", "app:\n  run:\n    /srv/app:\n      - {cmd: a, name: a, needs: b}\n      - {cmd: b, name: b, needs: a}\n", 4, 9},
+		{"unknown template", "app:\n  run:\n    /srv/app:\n      - date\n      - use: deploy\n", 5, 9},
+		{"unset variable", "app:\n  run:\n    /srv/app:\n      - echo ${DEPLOY_TEST_UNSET}\n", 4, 9},
+		{"variable in directory", "app:\n  run:\n    ${DEPLOY_TEST_UNSET}:\n      - date\n", 3, 5},
+		{"negative max_parallel", "app:\n  max_parallel: -1\n", 2, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write test config: %v", err)
+			}
+
+			_, err := Load(configPath)
+			var configErr *Error
+			if !errors.As(err, &configErr) {
+				t.Fatalf("expected a config Error, got: %v", err)
+			}
+			if configErr.File != configPath || configErr.Line != tt.line || configErr.Column != tt.column {
+				t.Errorf("expected %s:%d:%d, got %s:%d:%d", configPath, tt.line, tt.column, configErr.File, configErr.Line, configErr.Column)
+			}
+			if prefix := fmt.Sprintf("%s:%d:%d: ", configPath, tt.line, tt.column); !strings.HasPrefix(err.Error(), prefix) {
+				t.Errorf("expected message to start with %q, got: %v", prefix, err)
+			}
+		})
+	}
+}
+
+func TestLoad_UnknownKeyNamedOnce(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := "app:\n  run:\n    /srv/app:\n      - cmd: date\n        bogus: 1\n"
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	_, err := Load(configPath)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if want := ":5:9: package app: directory /srv/app: unknown command key \"bogus\""; !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("expected error to end with %q, got: %v", want, err)
+	}
+}
+
+func TestConfig_Check(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{
+		"b": {Run: Directories{{Dir: dir, Commands: []Command{{Cmd: "date", Children: []Command{{Cmd: "  "}}}}}}},
+		"a": {Setup: Directories{{Dir: file, Commands: []Command{{Cmd: "date"}}}}, Run: Directories{{Dir: dir}}},
+	}
+
+	var got []string
+	for _, err := range cfg.Check() {
+		got = append(got, err.Error())
+	}
+	expected := []string{
+		"package a: directory " + file + ": " + file + " is not a directory",
+		"package a: directory " + dir + ": no commands to run",
+		"package b: directory " + dir + ": command must not be empty",
+	}
+	if !slices.Equal(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestConfig_CheckPositions(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	content := "app:\n  run:\n    /nonexistent/steakpie-test:\n      - date\n    " + dir + ":\n      - date\n      - \" \"\n"
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	var got []string
+	for _, err := range cfg.Check() {
+		var configErr *Error
+		if !errors.As(err, &configErr) || configErr.File != configPath {
+			t.Fatalf("expected a config Error in %s, got: %v", configPath, err)
+		}
+		got = append(got, fmt.Sprintf("%d:%d", configErr.Line, configErr.Column))
+	}
+	if expected := []string{"3:5", "7:9"}; !slices.Equal(got, expected) {
+		t.Errorf("expected problems at %v, got %v", expected, got)
 	}
 }
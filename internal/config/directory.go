@@ -0,0 +1,163 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Directory is a working directory together with the commands to run in it.
+// Needs lists directories of the same phase that must succeed before this one starts.
+// Timeout and IdleTimeout apply to each command that doesn't set its own.
+// Env adds to the package's environment for every command in the directory.
+// Commands run as User and Group, which Load resolves into RunAs; nil means
+// steakpie's own user. CheckPrivileges clears RunAs where no switch is
+// needed. Pos is where the directory is defined, for errors.
+type Directory struct {
+	Dir         string
+	Commands    []Command
+	Needs       []string
+	Env         Env
+	User        string
+	Group       string
+	RunAs       *RunAs
+	Timeout     time.Duration
+	IdleTimeout time.Duration
+	Pos         Pos
+}
+
+// Directories is an ordered list of directories, kept in the order they
+// appear in the config file.
+type Directories []Directory
+
+// Commands returns the commands configured for dir.
+// Returns nil if the directory is not listed.
+func (d Directories) Commands(dir string) []Command {
+	entry, _ := d.find(dir)
+	return entry.Commands
+}
+
+// find returns the entry for dir and whether it is listed.
+func (d Directories) find(dir string) (Directory, bool) {
+	for _, entry := range d {
+		if entry.Dir == dir {
+			return entry, true
+		}
+	}
+	return Directory{}, false
+}
+
+// UnmarshalYAML implements custom YAML unmarshaling for Directories.
+// It handles two forms, both of which preserve document order:
+//   - Mapping: {"/srv/api": [...], "/srv/web": {needs: /srv/api, commands: [...]}}
+//     Each value is either a command list or a mapping with commands and needs.
+//   - Sequence: [{dir: /srv/api, commands: [...]}, {dir: /srv/web, needs: /srv/api, commands: [...]}]
+func (d *Directories) UnmarshalYAML(node *yaml.Node) error {
+	var dirs Directories
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			var entry Directory
+			if err := entry.decode(node.Content[i+1]); err != nil {
+				return at(node.Content[i+1], fmt.Errorf("directory %s: %w", node.Content[i].Value, err))
+			}
+			entry.Dir = node.Content[i].Value
+			entry.Pos = nodePos(node.Content[i])
+			dirs = append(dirs, entry)
+		}
+
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if item.Kind != yaml.MappingNode {
+				return at(item, fmt.Errorf("directory list entries must be mappings with dir and commands"))
+			}
+			var entry Directory
+			if err := entry.decode(item); err != nil {
+				return at(item, err)
+			}
+			if entry.Dir == "" {
+				return at(item, fmt.Errorf("directory list entry is missing dir"))
+			}
+			entry.Pos = nodePos(item)
+			dirs = append(dirs, entry)
+		}
+
+	default:
+		return at(node, fmt.Errorf("unexpected YAML node kind %d for directories", node.Kind))
+	}
+
+	// Needs may only point backwards, which keeps the order meaningful and rules out cycles.
+	seen := make(map[string]bool, len(dirs))
+	for _, entry := range dirs {
+		if seen[entry.Dir] {
+			return at(node, fmt.Errorf("directory %s is listed more than once", entry.Dir))
+		}
+		for _, need := range entry.Needs {
+			if !seen[need] {
+				return at(node, fmt.Errorf("directory %s needs %s, which must be listed before it", entry.Dir, need))
+			}
+		}
+		seen[entry.Dir] = true
+	}
+
+	*d = dirs
+	return nil
+}
+
+// decode fills a Directory from either a command list or a mapping with
+// dir, commands, needs, timeout, env, user and group keys.
+func (d *Directory) decode(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&d.Commands)
+	}
+	if node.Kind != yaml.MappingNode {
+		return at(node, fmt.Errorf("expected a command list or a mapping"))
+	}
+
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		var err error
+		switch key.Value {
+		case "dir":
+			d.Dir = value.Value
+		case "commands":
+			err = value.Decode(&d.Commands)
+		case "needs":
+			d.Needs, err = decodeStrings(value)
+		case "timeout":
+			d.Timeout, err = decodeDuration(value)
+		case "idle_timeout":
+			d.IdleTimeout, err = decodeDuration(value)
+		case "env", "env_file":
+			err = d.Env.decode(key.Value, value)
+		case "user":
+			d.User = value.Value
+		case "group":
+			d.Group = value.Value
+		default:
+			return at(key, fmt.Errorf("unknown directory key %q", key.Value))
+		}
+		if err != nil {
+			return at(value, fmt.Errorf("%s: %w", key.Value, err))
+		}
+	}
+	return nil
+}
+
+// decodeStrings accepts either a single string or a list of strings.
+func decodeStrings(node *yaml.Node) ([]string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []string{node.Value}, nil
+	case yaml.SequenceNode:
+		var values []string
+		if err := node.Decode(&values); err != nil {
+			return nil, err
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("expected a string or a list of strings")
+	}
+}
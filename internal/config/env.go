@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Env holds the environment settings of a package, directory or command.
+// Files are dotenv files, read each time a command runs, with relative paths
+// taken from the command's directory. Vars win over anything the files set.
+type Env struct {
+	Files Strings           `yaml:"env_file"`
+	Vars  map[string]string `yaml:"env"`
+}
+
+// decode fills in the env or env_file setting held by value.
+func (e *Env) decode(key string, value *yaml.Node) error {
+	switch key {
+	case "env":
+		if value.Kind != yaml.MappingNode {
+			return fmt.Errorf("expected a mapping of variable names to values")
+		}
+		return value.Decode(&e.Vars)
+	case "env_file":
+		return value.Decode(&e.Files)
+	}
+	return fmt.Errorf("unknown env key %q", key)
+}
+
+// Strings is a list of strings that may also be written as a single string.
+type Strings []string
+
+// UnmarshalYAML implements custom YAML unmarshaling for Strings.
+func (s *Strings) UnmarshalYAML(node *yaml.Node) error {
+	values, err := decodeStrings(node)
+	if err != nil {
+		return err
+	}
+	*s = values
+	return nil
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// confDir is the directory next to the main config file whose files are loaded after it.
+const confDir = "config.d"
+
+// source is a config file and its top-level mapping.
+type source struct {
+	path string
+	doc  *yaml.Node
+}
+
+// Sources returns the files loading path reads: the config file, the files
+// it includes, and the config.d directory and its files. Files read before an
+// error are still returned, so they can be watched for a fix.
+func Sources(path string) ([]string, error) {
+	sources, err := readSources(path)
+	var files []string
+	for _, src := range sources {
+		files = append(files, src.path)
+	}
+	dir := filepath.Join(filepath.Dir(path), confDir)
+	if info, statErr := os.Stat(path); statErr == nil && info.IsDir() {
+		dir = path
+	}
+	if info, statErr := os.Stat(dir); statErr == nil && info.IsDir() {
+		files = append(files, dir)
+	}
+	return files, err
+}
+
+// readSources reads path and everything it includes, in order, followed by
+// the files in the config.d directory next to it. If path is a directory,
+// its files are read instead. On error, the files read so far are returned
+// along with it.
+func readSources(path string) ([]source, error) {
+	r := sourceReader{seen: make(map[string]string)}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if info.IsDir() {
+		err := r.readDir(path)
+		return r.sources, err
+	}
+
+	if err := r.read(path, ""); err != nil {
+		return r.sources, err
+	}
+	dir := filepath.Join(filepath.Dir(path), confDir)
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		if err := r.readDir(dir); err != nil {
+			return r.sources, err
+		}
+	}
+	return r.sources, nil
+}
+
+// sourceReader collects config files, remembering which file pulled in each
+// one so a file read twice can be reported.
+type sourceReader struct {
+	sources []source
+	seen    map[string]string
+}
+
+// readDir reads every .yml and .yaml file in dir, in name order.
+func (r *sourceReader) readDir(dir string) error {
+	var files []string
+	for _, pattern := range []string{"*.yml", "*.yaml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return err
+		}
+		files = append(files, matches...)
+	}
+	slices.Sort(files)
+	for _, file := range files {
+		if err := r.read(file, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read reads a config file, then the files it includes. from names the file
+// or directory that pulled it in, empty for the main config file.
+func (r *sourceReader) read(path, from string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if first, ok := r.seen[abs]; ok {
+		return fmt.Errorf("%s: %s is already loaded by %s", from, path, orMain(first))
+	}
+	r.seen[abs] = orMain(from)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if from != "" {
+			return fmt.Errorf("%s: failed to read config file: %w", from, err)
+		}
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	// An empty file defines nothing, which Load reports if no other file does.
+	if len(root.Content) == 0 || len(root.Content[0].Content) == 0 {
+		r.sources = append(r.sources, source{path: path, doc: &yaml.Node{Kind: yaml.MappingNode}})
+		return nil
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return inFile(path, at(doc, fmt.Errorf("top level must be a mapping of package names")))
+	}
+	r.sources = append(r.sources, source{path: path, doc: doc})
+
+	for i := 0; i < len(doc.Content); i += 2 {
+		if doc.Content[i].Value != includeKey {
+			continue
+		}
+		patterns, err := decodeStrings(doc.Content[i+1])
+		if err != nil {
+			return inFile(path, at(doc.Content[i+1], fmt.Errorf("%s: %w", includeKey, err)))
+		}
+		for _, pattern := range patterns {
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(filepath.Dir(path), pattern)
+			}
+			files, err := filepath.Glob(pattern)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %w", path, includeKey, err)
+			}
+			// A pattern may match nothing, but a plain file name must exist.
+			if files == nil && !hasMeta(pattern) {
+				return fmt.Errorf("%s: %s: %w", path, includeKey, &os.PathError{Op: "open", Path: pattern, Err: os.ErrNotExist})
+			}
+			for _, file := range files {
+				if err := r.read(file, path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// orMain names the file that loaded another, where empty means the main config file.
+func orMain(from string) string {
+	if from == "" {
+		return "the main config file"
+	}
+	return from
+}
+
+// hasMeta reports whether path contains any of the characters recognised by filepath.Match.
+func hasMeta(path string) bool {
+	return slices.ContainsFunc([]rune(path), func(c rune) bool {
+		return c == '*' || c == '?' || c == '[' || c == '\\'
+	})
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeConfigs writes each file under dir, creating directories as needed.
+func writeConfigs(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test config: %v", err)
+		}
+	}
+}
+
+func TestLoad_IncludeAndConfDir(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigs(t, dir, map[string]string{
+		"config.yml": "include: [shared/templates.yml, teams/*.yml]\n" +
+			"defaults:\n  timeout: 5m\n" +
+			"app:\n  run:\n    /srv/app:\n      - echo app\n",
+		"shared/templates.yml": "templates:\n  up: docker compose up -d ${with.service}\n",
+		"teams/web.yml":        "web:\n  run:\n    /srv/web:\n      - use: up\n        with: {service: web}\n",
+		"config.d/billing.yml": "billing:\n  run:\n    /srv/billing:\n      - echo billing\n",
+		"config.d/empty.yaml":  "",
+		"config.d/notes.txt":   "not: config\n",
+	})
+
+	cfg, err := Load(filepath.Join(dir, "config.yml"))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	for _, name := range []string{"app", "web", "billing"} {
+		if _, ok := cfg[name]; !ok {
+			t.Errorf("expected package %s to be loaded", name)
+		}
+	}
+	if len(cfg) != 3 {
+		t.Errorf("expected 3 packages, got %d", len(cfg))
+	}
+	web := cfg["web"].Run[0]
+	if web.Commands[0].Cmd != "docker compose up -d web" {
+		t.Errorf("expected templates to be shared across files, got %q", web.Commands[0].Cmd)
+	}
+	if web.Timeout.Minutes() != 5 {
+		t.Errorf("expected defaults to apply to included packages, got %v", web.Timeout)
+	}
+}
+
+func TestLoad_ConfDirOnly(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigs(t, dir, map[string]string{
+		"a.yml":  "a:\n  run:\n    /srv/a:\n      - echo a\n",
+		"b.yaml": "b:\n  run:\n    /srv/b:\n      - echo b\n",
+	})
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(cfg) != 2 {
+		t.Errorf("expected 2 packages, got %d", len(cfg))
+	}
+}
+
+func TestLoad_IncludeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name: "duplicate package",
+			files: map[string]string{
+				"config.yml":     "app:\n  run:\n    /srv/app:\n      - echo one\n",
+				"config.d/b.yml": "app:\n  run:\n    /srv/app:\n      - echo two\n",
+			},
+			wantErr: "config.d/b.yml:1:1: package app is already defined in ",
+		},
+		{
+			name: "duplicate template",
+			files: map[string]string{
+				"config.yml": "include: more.yml\ntemplates:\n  up: date\napp: {}\n",
+				"more.yml":   "templates:\n  up: date\n",
+			},
+			wantErr: "more.yml:2:7: template up is already defined in ",
+		},
+		{
+			name: "defaults set twice",
+			files: map[string]string{
+				"config.yml":     "defaults:\n  timeout: 1m\napp: {}\n",
+				"config.d/b.yml": "defaults:\n  timeout: 2m\n",
+			},
+			wantErr: "config.d/b.yml:1:1: defaults are already set in ",
+		},
+		{
+			name: "missing include",
+			files: map[string]string{
+				"config.yml": "include: nope.yml\napp: {}\n",
+			},
+			wantErr: "config.yml: include: open ",
+		},
+		{
+			name: "included twice",
+			files: map[string]string{
+				"config.yml": "include: [a.yml, b.yml]\n",
+				"a.yml":      "include: b.yml\n",
+				"b.yml":      "app: {}\n",
+			},
+			wantErr: "b.yml is already loaded by ",
+		},
+		{
+			name: "error names the file",
+			files: map[string]string{
+				"config.yml":        "app: {}\n",
+				"config.d/team.yml": "web:\n  run:\n    /srv/web:\n      - echo ${DEPLOY_TEST_UNSET_VAR}\n",
+			},
+			wantErr: "config.d/team.yml:4:9: package web: ",
+		},
+		{
+			name: "parse error names the file",
+			files: map[string]string{
+				"config.yml":        "app: {}\n",
+				"config.d/team.yml": "web: [\n",
+			},
+			wantErr: "config.d/team.yml: yaml:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeConfigs(t, dir, tt.files)
+
+			_, err := Load(filepath.Join(dir, "config.yml"))
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error to contain %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSources(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigs(t, dir, map[string]string{
+		"config.yml":     "include: shared.yml\napp: {}\n",
+		"shared.yml":     "templates: {}\n",
+		"config.d/b.yml": "b: {}\n",
+	})
+
+	files, err := Sources(filepath.Join(dir, "config.yml"))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	expected := []string{
+		filepath.Join(dir, "config.yml"),
+		filepath.Join(dir, "shared.yml"),
+		filepath.Join(dir, "config.d", "b.yml"),
+		filepath.Join(dir, "config.d"),
+	}
+	if !slices.Equal(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+}
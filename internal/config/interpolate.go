@@ -0,0 +1,178 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/jc/steakpie/internal/event"
+)
+
+// SecretVars are never passed on to commands, whatever the config says.
+var SecretVars = []string{"WEBHOOK_SECRET", "WEBHOOK_SECRET_FILE"}
+
+// eventVarPrefix marks the variables steakpie sets for each command.
+const eventVarPrefix = "STEAKPIE_"
+
+// varName matches the names allowed in ${VAR} and ${VAR:-default}.
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// interpolate expands ${...} references in the package's directory keys,
+// needs, command strings and env settings.
+func (p *PackageConfig) interpolate() error {
+	if err := p.Env.interpolate(); err != nil {
+		return err
+	}
+	for _, dirs := range p.phases() {
+		seen := make(map[string]bool, len(dirs))
+		for i := range dirs {
+			d := &dirs[i]
+			dir, err := expand(d.Dir)
+			if err != nil {
+				return d.Pos.mark(fmt.Errorf("directory %s: %w", d.Dir, err))
+			}
+			for j, need := range d.Needs {
+				if d.Needs[j], err = expand(need); err != nil {
+					return d.Pos.mark(fmt.Errorf("directory %s: needs: %w", d.Dir, err))
+				}
+			}
+			if err := d.Env.interpolate(); err != nil {
+				return d.Pos.mark(fmt.Errorf("directory %s: %w", d.Dir, err))
+			}
+			if err := expandCommands(d.Commands); err != nil {
+				return d.Pos.mark(fmt.Errorf("directory %s: %w", d.Dir, err))
+			}
+			if seen[dir] {
+				return d.Pos.mark(fmt.Errorf("directory %s is listed more than once", dir))
+			}
+			seen[dir] = true
+			d.Dir = dir
+		}
+	}
+	return nil
+}
+
+// expandCommands expands ${...} references in each command and its children,
+// keeping the text as written in Raw when that changes it. References are only
+// expanded outside template actions, and their values are escaped so they
+// render as they are rather than as templates.
+func expandCommands(cmds []Command) error {
+	for i := range cmds {
+		written := cmds[i].Cmd
+		if argv := cmds[i].Argv; argv != nil {
+			written = strings.Join(argv, " ")
+			for j, arg := range argv {
+				expanded, err := event.Expand(arg, expand)
+				if err != nil {
+					return cmds[i].Pos.mark(err)
+				}
+				argv[j] = expanded
+			}
+			cmds[i].Cmd = strings.Join(argv, " ")
+		} else {
+			expanded, err := event.Expand(cmds[i].Cmd, expand)
+			if err != nil {
+				return cmds[i].Pos.mark(err)
+			}
+			cmds[i].Cmd = expanded
+		}
+		if cmds[i].Cmd != written {
+			cmds[i].Raw = written
+		}
+		if err := cmds[i].Env.interpolate(); err != nil {
+			return cmds[i].Pos.mark(err)
+		}
+		for _, branch := range cmds[i].branches() {
+			if err := expandCommands(branch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// interpolate expands ${...} references in env file paths and variable values.
+func (e *Env) interpolate() error {
+	for i, file := range e.Files {
+		expanded, err := expand(file)
+		if err != nil {
+			return fmt.Errorf("env_file: %w", err)
+		}
+		e.Files[i] = expanded
+	}
+	for name, value := range e.Vars {
+		expanded, err := expand(value)
+		if err != nil {
+			return fmt.Errorf("env %s: %w", name, err)
+		}
+		e.Vars[name] = expanded
+	}
+	return nil
+}
+
+// expand replaces references in s with their values:
+//   - ${VAR}: the environment variable VAR, which must be set
+//   - ${VAR:-default}: VAR, or default when VAR is unset or empty
+//   - ${file:/run/secrets/x}: the file's contents, without the trailing newline
+//
+// $${ is written out as a literal ${, for variables the shell should expand
+// when the command runs. ${STEAKPIE_*} is left alone for the same reason, as
+// steakpie sets those for each command. SecretVars can't be referenced.
+func expand(s string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if start > 0 && s[start-1] == '$' {
+			b.WriteString(s[:start-1] + "${")
+			s = s[start+2:]
+			continue
+		}
+
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", s[start:])
+		}
+		value, err := lookup(s[start+2 : start+end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s[:start] + value)
+		s = s[start+end+1:]
+	}
+}
+
+// lookup resolves the expression between ${ and }.
+func lookup(expr string) (string, error) {
+	if path, ok := strings.CutPrefix(expr, "file:"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("${file:%s}: %w", path, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	name, fallback, hasFallback := strings.Cut(expr, ":-")
+	if !varName.MatchString(name) {
+		return "", fmt.Errorf("invalid variable reference ${%s} (write $${ to leave it for the shell)", expr)
+	}
+	if strings.HasPrefix(name, eventVarPrefix) {
+		return "${" + expr + "}", nil
+	}
+	if slices.Contains(SecretVars, name) {
+		return "", fmt.Errorf("${%s} is never passed on to commands", name)
+	}
+	value, ok := os.LookupEnv(name)
+	switch {
+	case hasFallback && value == "":
+		return fallback, nil
+	case !ok:
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Provider supplies the configuration to use for the next webhook event.
+type Provider interface {
+	Current() Config
+}
+
+// Current returns the config itself, so a fixed Config can be used as a Provider.
+func (c Config) Current() Config {
+	return c
+}
+
+// Live holds the configuration currently in use and swaps it atomically when
+// the config file is reloaded. Commands already dispatched keep the package
+// configuration they started with.
+type Live struct {
+	path string
+	mu   sync.Mutex // serialises reloads
+	cfg  atomic.Pointer[Config]
+}
+
+// NewLive returns a Live config serving cfg, which was loaded from path.
+func NewLive(path string, cfg Config) *Live {
+	l := &Live{path: path}
+	l.cfg.Store(&cfg)
+	return l
+}
+
+// Current returns the configuration currently in use.
+func (l *Live) Current() Config {
+	return *l.cfg.Load()
+}
+
+// Reload loads the config file again and, if it is valid and steakpie may
+// switch to its users, swaps it in.
+// It returns a description of what changed. On error the current
+// configuration stays in place.
+func (l *Live) Reload() ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cfg, err := Load(l.path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.CheckPrivileges(); err != nil {
+		return nil, err
+	}
+
+	changes := Diff(l.Current(), cfg)
+	l.cfg.Store(&cfg)
+	return changes, nil
+}
+
+// Diff describes the packages and directories that were added, removed or
+// changed between two configurations, sorted by package name.
+func Diff(old, new Config) []string {
+	var changes []string
+	for _, name := range sortedNames(old, new) {
+		oldPkg, inOld := old[name]
+		newPkg, inNew := new[name]
+		switch {
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("package added: %s", name))
+		case !inNew:
+			changes = append(changes, fmt.Sprintf("package removed: %s", name))
+		default:
+			changes = append(changes, diffPackage(name, oldPkg, newPkg)...)
+		}
+	}
+	return changes
+}
+
+// diffPackage compares the directories of each phase, then everything else.
+// Positions are left out, as they move whenever lines are added above.
+func diffPackage(name string, old, new PackageConfig) []string {
+	old, new = old.unplaced(), new.unplaced()
+	var changes []string
+	phases := []struct {
+		name     string
+		old, new Directories
+	}{
+		{"setup", old.Setup, new.Setup},
+		{"run", old.Run, new.Run},
+		{"teardown", old.Teardown, new.Teardown},
+	}
+	for _, phase := range phases {
+		changes = append(changes, diffDirectories(name, phase.name, phase.old, phase.new)...)
+	}
+
+	old.Setup, old.Run, old.Teardown = nil, nil, nil
+	new.Setup, new.Run, new.Teardown = nil, nil, nil
+	if !reflect.DeepEqual(old, new) {
+		changes = append(changes, fmt.Sprintf("package changed: %s (settings or tags)", name))
+	}
+	return changes
+}
+
+func diffDirectories(pkg, phase string, old, new Directories) []string {
+	var changes []string
+	for _, d := range new {
+		prev, found := old.find(d.Dir)
+		switch {
+		case !found:
+			changes = append(changes, fmt.Sprintf("directory added: %s %s %s", pkg, phase, d.Dir))
+		case !reflect.DeepEqual(prev, d):
+			changes = append(changes, fmt.Sprintf("directory changed: %s %s %s", pkg, phase, d.Dir))
+		}
+	}
+	for _, d := range old {
+		if _, found := new.find(d.Dir); !found {
+			changes = append(changes, fmt.Sprintf("directory removed: %s %s %s", pkg, phase, d.Dir))
+		}
+	}
+	return changes
+}
+
+// unplaced returns a copy of the package without the positions of its
+// directories and commands, leaving the package itself untouched.
+func (p PackageConfig) unplaced() PackageConfig {
+	p.Setup, p.Run, p.Teardown = unplacedDirs(p.Setup), unplacedDirs(p.Run), unplacedDirs(p.Teardown)
+	if p.Tags != nil {
+		tags := make(TagRules, len(p.Tags))
+		for i, rule := range p.Tags {
+			rule.Setup, rule.Run, rule.Teardown = unplacedDirs(rule.Setup), unplacedDirs(rule.Run), unplacedDirs(rule.Teardown)
+			tags[i] = rule
+		}
+		p.Tags = tags
+	}
+	return p
+}
+
+func unplacedDirs(dirs Directories) Directories {
+	if dirs == nil {
+		return nil
+	}
+	copied := make(Directories, len(dirs))
+	for i, d := range dirs {
+		d.Pos = Pos{}
+		d.Commands = unplacedCommands(d.Commands)
+		copied[i] = d
+	}
+	return copied
+}
+
+func unplacedCommands(cmds []Command) []Command {
+	if cmds == nil {
+		return nil
+	}
+	copied := make([]Command, len(cmds))
+	for i, c := range cmds {
+		c.Pos = Pos{}
+		c.Children, c.OnFailure, c.Always = unplacedCommands(c.Children), unplacedCommands(c.OnFailure), unplacedCommands(c.Always)
+		copied[i] = c
+	}
+	return copied
+}
+
+// sortedNames returns the package names of both configs, sorted and without duplicates.
+func sortedNames(configs ...Config) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, cfg := range configs {
+		for name := range cfg {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLive_ReloadSwapsConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte("app:\n  run:\n    /srv/app:\n      - echo one\n"), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	live := NewLive(configPath, cfg)
+	before := live.Current()
+
+	if err := os.WriteFile(configPath, []byte("app:\n  run:\n    /srv/app:\n      - echo two\nweb:\n  run:\n    /srv/web:\n      - echo web\n"), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	changes, err := live.Reload()
+	if err != nil {
+		t.Fatalf("expected reload to succeed, got: %v", err)
+	}
+
+	expected := []string{
+		"directory changed: app run /srv/app",
+		"package added: web",
+	}
+	if !slices.Equal(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+
+	if len(live.Current()) != 2 {
+		t.Errorf("expected reloaded config with 2 packages, got %d", len(live.Current()))
+	}
+	if cmd := before["app"].Run.Commands("/srv/app")[0].Cmd; cmd != "echo one" {
+		t.Errorf("expected the previous config to be left untouched, got %q", cmd)
+	}
+}
+
+func TestLive_ReloadIgnoresMovedLines(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := "app:\n  tags:\n    v*:\n      run:\n        /srv/app: [echo tag]\n  run:\n    /srv/app:\n      - [echo one, echo child]\n"
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	live := NewLive(configPath, cfg)
+
+	if err := os.WriteFile(configPath, []byte("# deploys for app\n\n"+content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	changes, err := live.Reload()
+	if err != nil {
+		t.Fatalf("expected reload to succeed, got: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes when only line numbers move, got %v", changes)
+	}
+	if line := live.Current()["app"].Run[0].Pos.Line; line != 9 {
+		t.Errorf("expected the reloaded config to keep its positions, got line %d", line)
+	}
+	if line := cfg["app"].Run[0].Pos.Line; line != 7 {
+		t.Errorf("expected the previous config to be left untouched, got line %d", line)
+	}
+}
+
+func TestLive_ReloadKeepsConfigOnError(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte("app:\n  run:\n    /srv/app:\n      - echo one\n"), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	live := NewLive(configPath, cfg)
+
+	if err := os.WriteFile(configPath, []byte("app:\n  run:\n    /srv/app:\n      - []\n"), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	if _, err := live.Reload(); err == nil {
+		t.Fatal("expected reload of invalid config to fail")
+	}
+
+	if cmd := live.Current()["app"].Run.Commands("/srv/app")[0].Cmd; cmd != "echo one" {
+		t.Errorf("expected the old config to stay in place, got %q", cmd)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	mustPattern := func(s string) TagPattern {
+		p, err := ParseTagPattern(s)
+		if err != nil {
+			t.Fatalf("invalid pattern %q: %v", s, err)
+		}
+		return p
+	}
+
+	old := Config{
+		"same": {
+			Run:  Directories{{Dir: "/srv/same", Commands: []Command{{Cmd: "echo"}}}},
+			Tags: TagRules{{Pattern: mustPattern("/^v[0-9]+$/")}},
+		},
+		"gone": {Run: Directories{{Dir: "/srv/gone"}}},
+		"edited": {
+			MaxParallel: 1,
+			Setup:       Directories{{Dir: "/srv/db", Commands: []Command{{Cmd: "./snapshot"}}}},
+			Run: Directories{
+				{Dir: "/srv/api", Commands: []Command{{Cmd: "./migrate"}}},
+				{Dir: "/srv/old"},
+			},
+		},
+	}
+	new := Config{
+		"same": {
+			Run:  Directories{{Dir: "/srv/same", Commands: []Command{{Cmd: "echo"}}}},
+			Tags: TagRules{{Pattern: mustPattern("/^v[0-9]+$/")}},
+		},
+		"fresh": {Run: Directories{{Dir: "/srv/fresh"}}},
+		"edited": {
+			MaxParallel: 2,
+			Setup:       Directories{{Dir: "/srv/db", Commands: []Command{{Cmd: "./snapshot"}}}},
+			Run: Directories{
+				{Dir: "/srv/api", Commands: []Command{{Cmd: "./migrate --force"}}},
+				{Dir: "/srv/new"},
+			},
+		},
+	}
+
+	expected := []string{
+		"directory changed: edited run /srv/api",
+		"directory added: edited run /srv/new",
+		"directory removed: edited run /srv/old",
+		"package changed: edited (settings or tags)",
+		"package added: fresh",
+		"package removed: gone",
+	}
+	changes := Diff(old, new)
+	if !slices.Equal(changes, expected) {
+		t.Errorf("expected changes:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(changes, "\n"))
+	}
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Error is a config error at a line and column of a config file.
+type Error struct {
+	File   string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %v", e.File, e.Line, e.Column, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// position records where in its file an error was found. Its message is the
+// error's own; Load turns it into an Error once the file is known.
+type position struct {
+	line   int
+	column int
+	err    error
+}
+
+func (p *position) Error() string {
+	return p.err.Error()
+}
+
+func (p *position) Unwrap() error {
+	return p.err
+}
+
+// at marks err as found at node, unless it already carries a more precise position.
+func at(node *yaml.Node, err error) error {
+	return nodePos(node).mark(err)
+}
+
+// Pos is where a directory or command is defined: the file, which Load fills
+// in, and the line and column in it.
+type Pos struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// nodePos returns the position of node, in a file that isn't known yet.
+func nodePos(node *yaml.Node) Pos {
+	return Pos{Line: node.Line, Column: node.Column}
+}
+
+// mark marks err as found at p, unless it already carries a more precise
+// position or p is unknown.
+func (p Pos) mark(err error) error {
+	var pos *position
+	if err == nil || p.Line == 0 || errors.As(err, &pos) {
+		return err
+	}
+	return &position{line: p.Line, column: p.Column, err: err}
+}
+
+// inFile names the file err was found in, with the line and column when known.
+func inFile(file string, err error) error {
+	var pos *position
+	if errors.As(err, &pos) {
+		return &Error{File: file, Line: pos.line, Column: pos.column, Err: err}
+	}
+	return fmt.Errorf("%s: %w", file, err)
+}
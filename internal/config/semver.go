@@ -0,0 +1,222 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed semantic version. A leading "v" is accepted and ignored.
+type semver struct {
+	major, minor, patch int
+	pre                 []string
+}
+
+// parseSemver parses a full version such as "1.4.2", "v2.0.0" or "1.5.0-rc.1".
+// Build metadata after "+" is ignored.
+func parseSemver(s string) (semver, bool) {
+	v, parts, ok := parsePartialSemver(s)
+	return v, ok && parts == 3
+}
+
+// parsePartialSemver parses a version that may leave out minor and patch,
+// such as "2" or "1.4". It returns how many numeric parts were present.
+func parsePartialSemver(s string) (semver, int, bool) {
+	var v semver
+	s = strings.TrimPrefix(s, "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, pre, hasPre := strings.Cut(s, "-")
+	if hasPre {
+		if pre == "" {
+			return v, 0, false
+		}
+		v.pre = strings.Split(pre, ".")
+	}
+
+	nums := strings.Split(s, ".")
+	if len(nums) > 3 {
+		return v, 0, false
+	}
+	fields := []*int{&v.major, &v.minor, &v.patch}
+	for i, num := range nums {
+		n, err := strconv.Atoi(num)
+		if err != nil || n < 0 {
+			return v, 0, false
+		}
+		*fields[i] = n
+	}
+	if hasPre && len(nums) != 3 {
+		return v, 0, false
+	}
+	return v, len(nums), true
+}
+
+// compare returns -1, 0 or 1 following semver precedence rules.
+func (v semver) compare(o semver) int {
+	for _, d := range []int{v.major - o.major, v.minor - o.minor, v.patch - o.patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+
+	// A version without a pre-release outranks one with a pre-release.
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		a, aErr := strconv.Atoi(v.pre[i])
+		b, bErr := strconv.Atoi(o.pre[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if a != b {
+				return sign(a - b)
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(v.pre[i], o.pre[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return sign(len(v.pre) - len(o.pre))
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// comparator is a single operator and version, such as ">=1.4.0".
+type comparator struct {
+	op string
+	v  semver
+}
+
+func (c comparator) match(v semver) bool {
+	cmp := v.compare(c.v)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// semverConstraint is a list of alternatives, any of which may match.
+// Every comparator within an alternative must match. As with npm, a
+// pre-release version only matches an alternative that names a pre-release
+// of the same major.minor.patch, so ">=1.4.0 <2" doesn't pick up 2.0.0-rc.1.
+type semverConstraint [][]comparator
+
+func (c semverConstraint) match(v semver) bool {
+	for _, all := range c {
+		ok := len(v.pre) == 0
+		for _, cmp := range all {
+			if len(cmp.v.pre) > 0 && cmp.v.major == v.major && cmp.v.minor == v.minor && cmp.v.patch == v.patch {
+				ok = true
+			}
+		}
+		for _, cmp := range all {
+			if !cmp.match(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSemverConstraint parses constraints such as ">=1.4.0 <2", "~1.4" or "^1.2 || ^2".
+func parseSemverConstraint(s string) (semverConstraint, error) {
+	var c semverConstraint
+	for _, alt := range strings.Split(s, "||") {
+		fields := strings.Fields(alt)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("empty alternative")
+		}
+		var all []comparator
+		for _, field := range fields {
+			cmps, err := parseComparator(field)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, cmps...)
+		}
+		c = append(c, all)
+	}
+	return c, nil
+}
+
+// parseComparator parses one operator and version. Tilde and caret ranges
+// expand into a lower and an upper bound. Missing minor or patch parts count
+// as zero, except that "=1.4" matches any 1.4.x.
+func parseComparator(s string) ([]comparator, error) {
+	op := s[:len(s)-len(strings.TrimLeft(s, "<>=!~^"))]
+	v, parts, ok := parsePartialSemver(s[len(op):])
+	if !ok {
+		return nil, fmt.Errorf("invalid version in %q", s)
+	}
+
+	switch op {
+	case "", "=", "==":
+		if parts == 3 {
+			return []comparator{{"=", v}}, nil
+		}
+		return []comparator{{">=", v}, {"<", bump(v, parts)}}, nil
+	case "!=", ">", ">=", "<", "<=":
+		return []comparator{{op, v}}, nil
+	case "~":
+		upper := bump(v, min(parts, 2))
+		return []comparator{{">=", v}, {"<", upper}}, nil
+	case "^":
+		// Bump the first non-zero part, so ^0.3 allows 0.3.x but not 0.4.0.
+		switch {
+		case v.major > 0 || parts == 1:
+			return []comparator{{">=", v}, {"<", bump(v, 1)}}, nil
+		case v.minor > 0 || parts == 2:
+			return []comparator{{">=", v}, {"<", bump(v, 2)}}, nil
+		default:
+			return []comparator{{">=", v}, {"<", bump(v, 3)}}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operator %q", op)
+}
+
+// bump increments the given numeric part (1 = major, 2 = minor, 3 = patch)
+// and zeroes everything after it.
+func bump(v semver, part int) semver {
+	switch part {
+	case 1:
+		return semver{major: v.major + 1}
+	case 2:
+		return semver{major: v.major, minor: v.minor + 1}
+	default:
+		return semver{major: v.major, minor: v.minor, patch: v.patch + 1}
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Shell is the interpreter that runs command strings: a program and its
+// arguments, with the command appended as the last argument. A nil Shell
+// means the runner's default, bash -lc. The single word "none" runs
+// commands without a shell, splitting them into words like a shell would
+// but without expanding anything.
+type Shell []string
+
+// noShell is the value of shell that runs commands directly.
+const noShell = "none"
+
+// None reports whether commands should run without a shell.
+func (s Shell) None() bool {
+	return len(s) == 1 && s[0] == noShell
+}
+
+// loginShells are started as login shells, like the default bash -lc, so
+// they pick up the environment set in profile files.
+var loginShells = map[string]bool{"sh": true, "bash": true, "zsh": true}
+
+// UnmarshalYAML implements custom YAML unmarshaling for Shell.
+// It handles two forms:
+//   - Scalar: sh, bash, zsh or none, or an interpreter with its arguments such as "python3 -c"
+//     A lone program name other than sh, bash and zsh gets -c.
+//   - Sequence: [nu, --login, -c] → the interpreter and its arguments as written
+func (s *Shell) UnmarshalYAML(node *yaml.Node) error {
+	var args []string
+	switch node.Kind {
+	case yaml.ScalarNode:
+		args = strings.Fields(node.Value)
+		if len(args) == 1 && args[0] != noShell {
+			if loginShells[args[0]] {
+				args = append(args, "-lc")
+			} else {
+				args = append(args, "-c")
+			}
+		}
+	case yaml.SequenceNode:
+		if err := node.Decode(&args); err != nil {
+			return err
+		}
+	default:
+		return at(node, fmt.Errorf("shell must be a string or a list of strings"))
+	}
+	if len(args) == 0 {
+		return at(node, fmt.Errorf("shell must not be empty"))
+	}
+	*s = args
+	return nil
+}
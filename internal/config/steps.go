@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// StepDeps returns the indexes of the sibling commands that commands[i] waits
+// for: the steps it needs, then any other earlier siblings that capture output.
+// Names that aren't found are left out; Load rejects them.
+func StepDeps(commands []Command, i int) []int {
+	var deps []int
+	for _, need := range commands[i].Needs {
+		for j, c := range commands {
+			if c.Name == need {
+				deps = append(deps, j)
+				break
+			}
+		}
+	}
+	for j, c := range commands[:i] {
+		if c.Capture != "" && !slices.Contains(deps, j) {
+			deps = append(deps, j)
+		}
+	}
+	return deps
+}
+
+// checkSteps validates the step names and needs of every command list in the
+// package: names must be unique among siblings, needs must name a sibling, and
+// steps must not wait for each other in a cycle.
+func (p PackageConfig) checkSteps() error {
+	for _, dirs := range p.phases() {
+		for _, d := range dirs {
+			if err := checkSteps(d.Commands); err != nil {
+				return fmt.Errorf("directory %s: %w", d.Dir, err)
+			}
+		}
+	}
+	return nil
+}
+
+func checkSteps(commands []Command) error {
+	names := make(map[string]bool, len(commands))
+	for _, c := range commands {
+		if c.Name == "" {
+			continue
+		}
+		if names[c.Name] {
+			return c.Pos.mark(fmt.Errorf("step %s is named more than once", c.Name))
+		}
+		names[c.Name] = true
+	}
+	for _, c := range commands {
+		for _, need := range c.Needs {
+			if !names[need] {
+				return c.Pos.mark(fmt.Errorf("command %q needs %s, which is not a step at the same level", c.Shown(), need))
+			}
+		}
+	}
+	if cycle := findCycle(commands); cycle != nil {
+		labels := make([]string, len(cycle))
+		for k, j := range cycle {
+			labels[k] = stepLabel(commands[j])
+		}
+		return commands[cycle[0]].Pos.mark(fmt.Errorf("steps wait for each other in a cycle: %s", strings.Join(labels, " → ")))
+	}
+
+	for _, c := range commands {
+		for _, branch := range c.branches() {
+			if err := checkSteps(branch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findCycle returns the indexes of a dependency cycle's commands, starting
+// and ending with the same one, or nil if there is none.
+func findCycle(commands []Command) []int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(commands))
+	var path []int
+
+	var visit func(i int) []int
+	visit = func(i int) []int {
+		switch state[i] {
+		case visiting:
+			start := 0
+			for path[start] != i {
+				start++
+			}
+			return append(slices.Clone(path[start:]), i)
+		case visited:
+			return nil
+		}
+		state[i] = visiting
+		path = append(path, i)
+		for _, dep := range StepDeps(commands, i) {
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+
+	for i := range commands {
+		if cycle := visit(i); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// stepLabel names a command in error messages: its step name, or the command itself.
+func stepLabel(c Command) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return fmt.Sprintf("%q", c.Shown())
+}
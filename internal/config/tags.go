@@ -0,0 +1,203 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultTag is the only tag a package reacts to when it doesn't list any.
+const defaultTag = "latest"
+
+// TagPattern matches container tag names. It is written as one of:
+//   - an exact tag name: latest
+//   - a glob: sha-*
+//   - a regular expression between slashes: /^v[0-9]+$/
+//   - a semver constraint: >=1.4.0 <2 (space means and, || means or)
+type TagPattern struct {
+	raw        string
+	glob       bool
+	re         *regexp.Regexp
+	constraint semverConstraint
+}
+
+// ParseTagPattern parses and validates a tag pattern.
+func ParseTagPattern(s string) (TagPattern, error) {
+	p := TagPattern{raw: s}
+	switch {
+	case s == "":
+		return p, fmt.Errorf("tag pattern must not be empty")
+
+	case len(s) > 1 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/"):
+		re, err := regexp.Compile(s[1 : len(s)-1])
+		if err != nil {
+			return p, fmt.Errorf("invalid tag regex %s: %w", s, err)
+		}
+		p.re = re
+
+	case strings.ContainsAny(s[:1], "<>=!~^"):
+		c, err := parseSemverConstraint(s)
+		if err != nil {
+			return p, fmt.Errorf("invalid semver constraint %q: %w", s, err)
+		}
+		p.constraint = c
+
+	case strings.ContainsAny(s, "*?["):
+		if _, err := path.Match(s, ""); err != nil {
+			return p, fmt.Errorf("invalid tag glob %q: %w", s, err)
+		}
+		p.glob = true
+	}
+	return p, nil
+}
+
+// Match reports whether tag satisfies the pattern.
+func (p TagPattern) Match(tag string) bool {
+	switch {
+	case p.re != nil:
+		return p.re.MatchString(tag)
+	case p.constraint != nil:
+		v, ok := parseSemver(tag)
+		return ok && p.constraint.match(v)
+	case p.glob:
+		ok, _ := path.Match(p.raw, tag)
+		return ok
+	default:
+		return p.raw == tag
+	}
+}
+
+// String returns the pattern as written in the config file.
+func (p TagPattern) String() string {
+	return p.raw
+}
+
+// UnmarshalYAML implements custom YAML unmarshaling for TagPattern.
+func (p *TagPattern) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return at(node, fmt.Errorf("tag pattern must be a string"))
+	}
+	parsed, err := ParseTagPattern(node.Value)
+	if err != nil {
+		return at(node, err)
+	}
+	*p = parsed
+	return nil
+}
+
+// TagRule is a tag pattern, optionally with its own phases. When Override is
+// set, a matching tag runs the rule's setup, run and teardown instead of the
+// package's own.
+type TagRule struct {
+	Pattern  TagPattern
+	Override bool
+	Setup    Directories
+	Run      Directories
+	Teardown Directories
+}
+
+// TagRules is an ordered list of tag rules; the first matching rule wins.
+type TagRules []TagRule
+
+// UnmarshalYAML implements custom YAML unmarshaling for TagRules.
+// It handles two forms:
+//   - Sequence: [latest, "v*"] → patterns that run the package's own phases
+//   - Mapping: {staging: {run: ...}, latest: {run: ...}} → patterns with their own phases
+//     A null value runs the package's own phases.
+func (r *TagRules) UnmarshalYAML(node *yaml.Node) error {
+	var rules TagRules
+
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			var rule TagRule
+			if err := item.Decode(&rule.Pattern); err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+		}
+
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			var rule TagRule
+			if err := node.Content[i].Decode(&rule.Pattern); err != nil {
+				return err
+			}
+			if err := rule.decodeBlock(node.Content[i+1]); err != nil {
+				return at(node.Content[i+1], fmt.Errorf("tag %s: %w", rule.Pattern, err))
+			}
+			rules = append(rules, rule)
+		}
+
+	default:
+		return at(node, fmt.Errorf("tags must be a list of patterns or a mapping of patterns to run blocks"))
+	}
+
+	*r = rules
+	return nil
+}
+
+// decodeBlock fills the rule's phases from a mapping with setup, run and teardown keys.
+func (r *TagRule) decodeBlock(node *yaml.Node) error {
+	if node.Tag == "!!null" {
+		return nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("expected a mapping with run, setup or teardown")
+	}
+
+	r.Override = true
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		var err error
+		switch key.Value {
+		case "setup":
+			err = value.Decode(&r.Setup)
+		case "run":
+			err = value.Decode(&r.Run)
+		case "teardown":
+			err = value.Decode(&r.Teardown)
+		default:
+			return at(key, fmt.Errorf("unknown tag block key %q", key.Value))
+		}
+		if err != nil {
+			return at(value, fmt.Errorf("%s: %w", key.Value, err))
+		}
+	}
+	return nil
+}
+
+// MatchTag reports whether the package reacts to the given tag.
+// Packages without tag patterns only react to "latest".
+func (p PackageConfig) MatchTag(tag string) bool {
+	_, ok := p.ForTag(tag)
+	return ok
+}
+
+// ForTag returns the package configuration to run for the given tag, with the
+// matching rule's phases in place of the package's own when the rule has them.
+// The boolean is false if the package doesn't react to the tag.
+func (p PackageConfig) ForTag(tag string) (PackageConfig, bool) {
+	if len(p.Tags) == 0 {
+		return p, tag == defaultTag
+	}
+	rule, ok := p.Rule(tag)
+	if ok && rule.Override {
+		p.Setup, p.Run, p.Teardown = rule.Setup, rule.Run, rule.Teardown
+	}
+	return p, ok
+}
+
+// Rule returns the first of the package's tag rules that matches tag.
+// The boolean is false if none does, including when the package has no rules.
+func (p PackageConfig) Rule(tag string) (TagRule, bool) {
+	for _, rule := range p.Tags {
+		if rule.Pattern.Match(tag) {
+			return rule, true
+		}
+	}
+	return TagRule{}, false
+}
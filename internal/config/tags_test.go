@@ -0,0 +1,89 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTagPattern_Match(t *testing.T) {
+	tests := []struct {
+		pattern string
+		tag     string
+		want    bool
+	}{
+		{"latest", "latest", true},
+		{"latest", "latest-dev", false},
+		{"main", "main", true},
+		{"sha-*", "sha-abc123", true},
+		{"sha-*", "main", false},
+		{"v1.?.0", "v1.2.0", true},
+		{"/^sha-[0-9a-f]{6}$/", "sha-abc123", true},
+		{"/^sha-[0-9a-f]{6}$/", "sha-abc1234", false},
+		{">=1.4.0 <2", "v1.4.0", true},
+		{">=1.4.0 <2", "1.9.12", true},
+		{">=1.4.0 <2", "v2.0.0", false},
+		{">=1.4.0 <2", "v1.3.9", false},
+		{">=1.4.0 <2", "2.0.0-rc.1", false},
+		{">=1.4.0 <2", "latest", false},
+		{">=1.4.0 <2", "v1.4", false},
+		{"~1.4", "1.4.7", true},
+		{"~1.4", "1.5.0", false},
+		{"~1.4.2", "1.4.1", false},
+		{"^1.2", "1.9.0", true},
+		{"^1.2", "2.0.0", false},
+		{"^0.3", "0.3.5", true},
+		{"^0.3", "0.4.0", false},
+		{"^1 || ^3", "3.1.0", true},
+		{"^1 || ^3", "2.1.0", false},
+		{"=1.4", "1.4.3", true},
+		{"!=1.4.0", "1.4.1", true},
+		{">=1.5.0-rc.1", "1.5.0-rc.2", true},
+		{">=1.5.0-rc.1", "1.5.0-rc.0", false},
+		{">=1.5.0-rc.1", "1.5.0", true},
+	}
+
+	for _, tt := range tests {
+		p, err := ParseTagPattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParseTagPattern(%q): unexpected error: %v", tt.pattern, err)
+		}
+		if got := p.Match(tt.tag); got != tt.want {
+			t.Errorf("%q matching %q: expected %v, got %v", tt.pattern, tt.tag, tt.want, got)
+		}
+	}
+}
+
+func TestParseTagPattern_Invalid(t *testing.T) {
+	tests := []struct {
+		pattern string
+		errMsg  string
+	}{
+		{"", "must not be empty"},
+		{"/[unclosed/", "invalid tag regex"},
+		{">=one", "invalid semver constraint"},
+		{">=1.0 ||", "invalid semver constraint"},
+		{"v[1", "invalid tag glob"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseTagPattern(tt.pattern)
+		if err == nil {
+			t.Errorf("ParseTagPattern(%q): expected error, got nil", tt.pattern)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.errMsg) {
+			t.Errorf("ParseTagPattern(%q): expected error containing %q, got: %v", tt.pattern, tt.errMsg, err)
+		}
+	}
+}
+
+func TestMatchTag_DefaultsToLatest(t *testing.T) {
+	var pkg PackageConfig
+
+	if !pkg.MatchTag("latest") {
+		t.Error("expected package without tags to match latest")
+	}
+	if pkg.MatchTag("main") {
+		t.Error("expected package without tags not to match main")
+	}
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/jc/steakpie/internal/event"
+)
+
+// checkTemplates renders every command template in the package against an
+// empty event, so mistakes like unknown fields show up when the config loads
+// rather than when a webhook arrives. Each command may use the outputs its
+// ancestors could, plus those seen or captured by the siblings it waits for.
+// Children also see their parent's output; on_failure and always commands
+// can't rely on it, since it is only captured on success.
+func (p PackageConfig) checkTemplates() error {
+	for _, dirs := range p.phases() {
+		for _, d := range dirs {
+			if err := checkCommands(d.Commands, nil); err != nil {
+				return fmt.Errorf("directory %s: %w", d.Dir, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkCommands checks a list of sibling commands, which checkSteps has
+// already made sure don't wait for each other in a cycle.
+func checkCommands(cmds []Command, outputs []string) error {
+	visible := make([][]string, len(cmds))
+	var see func(i int) []string
+	see = func(i int) []string {
+		if visible[i] == nil {
+			seen := slices.Clip(outputs)
+			for _, j := range StepDeps(cmds, i) {
+				seen = append(seen, see(j)...)
+				if cmds[j].Capture != "" {
+					seen = append(seen, cmds[j].Capture)
+				}
+			}
+			visible[i] = slices.Clip(seen)
+		}
+		return visible[i]
+	}
+
+	for i, cmd := range cmds {
+		seen := see(i)
+		texts := cmd.Argv
+		if texts == nil {
+			texts = []string{cmd.Cmd}
+		}
+		for _, text := range texts {
+			if err := event.Check(text, seen); err != nil {
+				return cmd.Pos.mark(fmt.Errorf("command %q: %w", cmd.Shown(), err))
+			}
+		}
+		children := seen
+		if cmd.Capture != "" {
+			children = append(slices.Clip(seen), cmd.Capture)
+		}
+		if err := checkCommands(cmd.Children, children); err != nil {
+			return err
+		}
+		for _, branch := range [][]Command{cmd.OnFailure, cmd.Always} {
+			if err := checkCommands(branch, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
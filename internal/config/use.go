@@ -0,0 +1,146 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Templates holds the top-level templates section: named commands, in any of
+// the forms a command can take, that packages expand with use.
+type Templates map[string]*yaml.Node
+
+// UnmarshalYAML implements custom YAML unmarshaling for Templates.
+// Each template is kept as YAML, since its parameters are only filled in when it is used.
+func (t *Templates) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return at(node, fmt.Errorf("templates must be a mapping of names to commands"))
+	}
+	templates := make(Templates, len(node.Content)/2)
+	for i := 0; i < len(node.Content); i += 2 {
+		templates[node.Content[i].Value] = node.Content[i+1]
+	}
+	*t = templates
+	return nil
+}
+
+// withRef matches a template parameter, ${with.NAME} or ${with.NAME:-default},
+// along with a leading $ that escapes it.
+var withRef = regexp.MustCompile(`\$?\$\{with\.([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// useTemplates replaces every command of the package that uses a template
+// with the template's command, parameters filled in.
+func (p *PackageConfig) useTemplates(templates Templates) error {
+	for _, dirs := range p.phases() {
+		for i := range dirs {
+			if err := templates.expand(dirs[i].Commands, nil); err != nil {
+				return fmt.Errorf("directory %s: %w", dirs[i].Dir, err)
+			}
+		}
+	}
+	return nil
+}
+
+// expand replaces the commands that use a template in place, along with those
+// in their branches. using lists the templates being expanded, to catch a
+// template that ends up using itself.
+func (t Templates) expand(cmds []Command, using []string) error {
+	for i := range cmds {
+		c := &cmds[i]
+		if c.Use != "" {
+			cmd, err := t.use(*c, using)
+			if err != nil {
+				return c.Pos.mark(err)
+			}
+			*c = cmd
+			continue
+		}
+		for _, branch := range c.branches() {
+			if err := t.expand(branch, using); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// use returns the command c's template expands to, keeping c's name and needs.
+func (t Templates) use(c Command, using []string) (Command, error) {
+	node, ok := t[c.Use]
+	if !ok {
+		return Command{}, fmt.Errorf("unknown template %s", c.Use)
+	}
+	if slices.Contains(using, c.Use) {
+		return Command{}, fmt.Errorf("template %s uses itself", c.Use)
+	}
+
+	used := make(map[string]bool, len(c.With))
+	node, err := fillParams(node, c.With, used)
+	if err != nil {
+		return Command{}, fmt.Errorf("template %s: %w", c.Use, err)
+	}
+	for param := range c.With {
+		if !used[param] {
+			return Command{}, fmt.Errorf("template %s has no parameter %s", c.Use, param)
+		}
+	}
+
+	var cmd Command
+	if err := node.Decode(&cmd); err != nil {
+		return Command{}, fmt.Errorf("template %s: %w", c.Use, err)
+	}
+	cmds := []Command{cmd}
+	if err := t.expand(cmds, append(slices.Clip(using), c.Use)); err != nil {
+		return Command{}, fmt.Errorf("template %s: %w", c.Use, err)
+	}
+	eachCommand(cmds, func(used *Command) { used.Pos = c.Pos })
+	cmd = cmds[0]
+
+	if c.Name != "" {
+		cmd.Name = c.Name
+	}
+	if c.Needs != nil {
+		cmd.Needs = c.Needs
+	}
+	return cmd, nil
+}
+
+// fillParams returns a copy of node with the parameters in its strings
+// replaced by their values, recording the ones it used. $${with.NAME} is left
+// for interpolation to turn into a literal ${with.NAME}.
+func fillParams(node *yaml.Node, with map[string]string, used map[string]bool) (*yaml.Node, error) {
+	filled := *node
+	if node.Kind == yaml.ScalarNode {
+		var err error
+		filled.Value = withRef.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			if ref[1] == '$' {
+				return ref
+			}
+			m := withRef.FindStringSubmatchIndex(ref)
+			name := ref[m[2]:m[3]]
+			if value, ok := with[name]; ok {
+				used[name] = true
+				return value
+			}
+			if m[4] < 0 {
+				if err == nil {
+					err = fmt.Errorf("parameter %s is not set", name)
+				}
+				return ref
+			}
+			return ref[m[4]:m[5]]
+		})
+		return &filled, err
+	}
+
+	filled.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		var err error
+		if filled.Content[i], err = fillParams(child, with, used); err != nil {
+			return nil, err
+		}
+	}
+	return &filled, nil
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+)
+
+// RunAs is a resolved Unix user and group that commands run as.
+type RunAs struct {
+	Name   string // the user's login name
+	Home   string
+	Uid    uint32
+	Gid    uint32
+	Groups []uint32 // supplementary groups
+}
+
+// resolveUsers looks up the user and group of every directory, inheriting the
+// package's settings where a directory has none of its own. It fails if a
+// user or group doesn't exist. Whether steakpie may switch to them is left to
+// CheckPrivileges, as that depends on who runs steakpie rather than the config.
+func (p *PackageConfig) resolveUsers() error {
+	for _, dirs := range p.phases() {
+		for i := range dirs {
+			d := &dirs[i]
+			if d.User == "" {
+				d.User = p.User
+			}
+			if d.Group == "" {
+				d.Group = p.Group
+			}
+			if d.User == "" && d.Group == "" {
+				continue
+			}
+			runAs, err := lookupRunAs(d.User, d.Group)
+			if err != nil {
+				return d.Pos.mark(fmt.Errorf("directory %s: %w", d.Dir, err))
+			}
+			d.RunAs = runAs
+		}
+	}
+	return nil
+}
+
+// lookupRunAs resolves a user and group, each given as a name or a numeric id.
+// Without a user, commands keep steakpie's user; without a group, they get the
+// user's primary group. Supplementary groups are the user's, or none when
+// steakpie's own user is kept.
+func lookupRunAs(userName, groupName string) (*RunAs, error) {
+	var u *user.User
+	var err error
+	if userName == "" {
+		u, err = user.Current()
+	} else {
+		u, err = lookupUser(userName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	runAs := &RunAs{Name: u.Username, Home: u.HomeDir}
+	if runAs.Uid, err = parseID(u.Uid); err != nil {
+		return nil, fmt.Errorf("user %s: %w", u.Username, err)
+	}
+	if runAs.Gid, err = parseID(u.Gid); err != nil {
+		return nil, fmt.Errorf("user %s: %w", u.Username, err)
+	}
+	if userName != "" {
+		ids, err := u.GroupIds()
+		if err != nil {
+			return nil, fmt.Errorf("user %s: cannot list groups: %w", u.Username, err)
+		}
+		for _, id := range ids {
+			gid, err := parseID(id)
+			if err != nil {
+				return nil, fmt.Errorf("user %s: %w", u.Username, err)
+			}
+			runAs.Groups = append(runAs.Groups, gid)
+		}
+	}
+
+	if groupName != "" {
+		g, err := lookupGroup(groupName)
+		if err != nil {
+			return nil, err
+		}
+		if runAs.Gid, err = parseID(g.Gid); err != nil {
+			return nil, fmt.Errorf("group %s: %w", groupName, err)
+		}
+	}
+	return runAs, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		u, err := user.LookupId(name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown user id %s", name)
+		}
+		return u, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %s", name)
+	}
+	return u, nil
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		g, err := user.LookupGroupId(name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown group id %s", name)
+		}
+		return g, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown group %s", name)
+	}
+	return g, nil
+}
+
+func parseID(s string) (uint32, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %q", s)
+	}
+	return uint32(id), nil
+}
+
+// CheckPrivileges reports an error if steakpie, as the user it runs as, can't
+// switch to the user and group of every directory. Directories already set to
+// steakpie's own user and group are left to run without a switch. The server
+// calls it on every config it loads; validate doesn't, so a config can be
+// checked by a user other than the one that runs it.
+func (c Config) CheckPrivileges() error {
+	return c.checkPrivileges(os.Geteuid(), os.Getegid())
+}
+
+func (c Config) checkPrivileges(euid, egid int) error {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		for _, dirs := range c[name].phases() {
+			for i := range dirs {
+				d := &dirs[i]
+				if d.RunAs == nil {
+					continue
+				}
+				runAs, err := checkSwitch(euid, egid, d.RunAs)
+				if err != nil {
+					err = d.Pos.mark(fmt.Errorf("package %s: directory %s: %w", name, d.Dir, err))
+					if d.Pos.File != "" {
+						err = inFile(d.Pos.File, err)
+					}
+					return err
+				}
+				d.RunAs = runAs
+			}
+		}
+	}
+	return nil
+}
+
+// checkSwitch reports an error if a process with the given effective user and
+// group ids can't switch to runAs. Only root can change to another user or
+// group. When runAs is who the process already is, no switch is needed and
+// checkSwitch returns nil.
+func checkSwitch(euid, egid int, runAs *RunAs) (*RunAs, error) {
+	if euid == 0 {
+		return runAs, nil
+	}
+	if int(runAs.Uid) != euid || int(runAs.Gid) != egid {
+		return nil, fmt.Errorf("running as user %s needs steakpie to run as root", runAs.Name)
+	}
+	return nil, nil
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad_Users(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+
+	content := `mypackage:
+  user: nobody
+  run:
+    /srv/inherits:
+      - echo a
+    /srv/override:
+      user: "0"
+      group: nogroup
+      commands:
+        - echo b
+    /srv/group-only:
+      group: "1"
+      commands:
+        - echo c
+other:
+  run:
+    /srv/plain:
+      - echo d
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	run := cfg["mypackage"].Run
+	if r := run[0].RunAs; r == nil || r.Name != "nobody" || r.Uid != 65534 || r.Gid != 65534 {
+		t.Errorf("expected directory to inherit user nobody, got %+v", r)
+	}
+	if r := run[1].RunAs; r == nil || r.Name != "root" || r.Uid != 0 || r.Gid != 65534 {
+		t.Errorf("expected root with group nogroup, got %+v", r)
+	}
+	if r := run[2].RunAs; r == nil || r.Name != "nobody" || r.Gid != 1 {
+		t.Errorf("expected package user with group 1, got %+v", r)
+	}
+	if r := cfg["other"].Run[0].RunAs; r != nil {
+		t.Errorf("expected no user switch without user or group, got %+v", r)
+	}
+}
+
+func TestLoad_UnknownUserOrGroup(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"unknown user", "mypackage:\n  user: no-such-user-here\n  run:\n    /srv/app:\n      - echo hi\n", "unknown user no-such-user-here"},
+		{"unknown user id", "mypackage:\n  run:\n    /srv/app:\n      user: \"4000000000\"\n      commands:\n        - echo hi\n", "unknown user id 4000000000"},
+		{"unknown group", "mypackage:\n  run:\n    /srv/app:\n      group: no-such-group-here\n      commands:\n        - echo hi\n", "unknown group no-such-group-here"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write test config: %v", err)
+			}
+
+			_, err := Load(configPath)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			for _, want := range []string{"package mypackage", "directory /srv/app", tt.wantErr} {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to contain %q, got: %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckPrivileges(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := "mypackage:\n  run:\n    /srv/self:\n      user: \"1000\"\n      commands:\n        - echo a\n    /srv/other:\n      user: nobody\n      commands:\n        - echo b\n"
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Skipf("user 1000 is needed for this test: %v", err)
+	}
+
+	if err := cfg.checkPrivileges(0, 0); err != nil {
+		t.Errorf("expected root to switch to any user, got: %v", err)
+	}
+
+	err = cfg.checkPrivileges(1000, int(cfg["mypackage"].Run[0].RunAs.Gid))
+	want := configPath + ":7:5: package mypackage: directory /srv/other: running as user nobody needs steakpie to run as root"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected error %q, got: %v", want, err)
+	}
+	if r := cfg["mypackage"].Run[0].RunAs; r != nil {
+		t.Errorf("expected no switch to steakpie's own user, got %+v", r)
+	}
+}
+
+func TestCheckSwitch(t *testing.T) {
+	nobody := &RunAs{Name: "nobody", Uid: 65534, Gid: 65534}
+
+	if runAs, err := checkSwitch(0, 0, nobody); err != nil || runAs != nobody {
+		t.Errorf("expected root to switch to any user, got %v, %v", runAs, err)
+	}
+	if runAs, err := checkSwitch(65534, 65534, nobody); err != nil || runAs != nil {
+		t.Errorf("expected no switch when already running as the user, got %v, %v", runAs, err)
+	}
+	if _, err := checkSwitch(1000, 1000, nobody); err == nil || !strings.Contains(err.Error(), "needs steakpie to run as root") {
+		t.Errorf("expected a non-root process to be refused, got %v", err)
+	}
+	if _, err := checkSwitch(65534, 1000, nobody); err == nil {
+		t.Error("expected a different group to be refused")
+	}
+}
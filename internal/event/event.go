@@ -0,0 +1,48 @@
+// Package event describes the webhook delivery that triggered a deploy, in the
+// form commands get to see it.
+package event
+
+import "strconv"
+
+// Event is a verified registry_package delivery for a package and tag the
+// config reacts to. Command templates are rendered against it, so
+// {{.Package.Name}} and {{.Version.Digest}} refer to its fields, and
+// {{.Outputs.NAME}} to the output captured by an earlier command.
+type Event struct {
+	Action     string
+	Package    Package
+	Version    Version
+	Tag        string
+	DeliveryID string
+	Repository string // owner/name
+	Sender     string
+	Payload    []byte // the raw JSON body, as signed by GitHub
+	Outputs    map[string]string
+}
+
+// Package is the registry package that was published.
+type Package struct {
+	Name      string
+	Ecosystem string
+}
+
+// Version is the package version that was published.
+type Version struct {
+	ID     int64
+	SHA    string // the version name, e.g. sha256:...
+	Digest string // the tag's image digest
+	URL    string
+}
+
+// Env returns the event as STEAKPIE_* environment variables, in KEY=value form.
+func (e Event) Env() []string {
+	return []string{
+		"STEAKPIE_PACKAGE=" + e.Package.Name,
+		"STEAKPIE_TAG=" + e.Tag,
+		"STEAKPIE_SHA=" + e.Version.SHA,
+		"STEAKPIE_VERSION_ID=" + strconv.FormatInt(e.Version.ID, 10),
+		"STEAKPIE_DELIVERY_ID=" + e.DeliveryID,
+		"STEAKPIE_REPOSITORY=" + e.Repository,
+		"STEAKPIE_SENDER=" + e.Sender,
+	}
+}
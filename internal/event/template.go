@@ -0,0 +1,137 @@
+package event
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	tparse "text/template/parse"
+)
+
+// funcs are the helpers available in command templates. Functions taking a
+// string take it last, so they read naturally in a pipeline:
+// {{ .Tag | trimPrefix "v" }}.
+var funcs = template.FuncMap{
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"quote":      shellQuote,
+}
+
+// isTemplate reports whether s contains template actions. Strings without
+// any are used as they are.
+func isTemplate(s string) bool {
+	return strings.Contains(s, "{{")
+}
+
+// parse parses a command template. Unknown map keys are an error, as unknown
+// struct fields already are.
+func parse(text string) (*template.Template, error) {
+	return template.New("command").Funcs(funcs).Option("missingkey=error").Parse(text)
+}
+
+// Check reports whether text is a valid template that renders against an
+// event with the given outputs, catching syntax errors, unknown functions and
+// unknown fields before any webhook arrives.
+func Check(text string, outputs []string) error {
+	ev := Event{Outputs: make(map[string]string, len(outputs))}
+	for _, name := range outputs {
+		ev.Outputs[name] = ""
+	}
+	_, err := ev.Render(text)
+	return err
+}
+
+// Expand applies expand to the text of a command template outside its
+// actions and returns a template that renders the expanded text as it is, so
+// a value containing {{ never becomes an action. Text that doesn't parse is
+// returned as written, for Check to report without any expanded values.
+func Expand(text string, expand func(string) (string, error)) (string, error) {
+	if !isTemplate(text) {
+		expanded, err := expand(text)
+		if err != nil {
+			return "", err
+		}
+		return literal(expanded, false), nil
+	}
+	tmpl, err := parse(text)
+	if err != nil {
+		return text, nil
+	}
+
+	var spans []*tparse.TextNode
+	for _, t := range tmpl.Templates() {
+		spans = textNodes(t.Root, spans)
+	}
+	slices.SortFunc(spans, func(a, b *tparse.TextNode) int { return int(a.Pos - b.Pos) })
+
+	var b strings.Builder
+	last := 0
+	for _, span := range spans {
+		start, end := int(span.Pos), int(span.Pos)+len(span.Text)
+		expanded, err := expand(text[start:end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(literal(expanded, end < len(text)))
+		last = end
+	}
+	b.WriteString(text[last:])
+	return b.String(), nil
+}
+
+// textNodes appends the text nodes of a parsed template to nodes, including
+// those in the bodies of if, range and with.
+func textNodes(node tparse.Node, nodes []*tparse.TextNode) []*tparse.TextNode {
+	switch n := node.(type) {
+	case *tparse.TextNode:
+		nodes = append(nodes, n)
+	case *tparse.ListNode:
+		if n != nil {
+			for _, child := range n.Nodes {
+				nodes = textNodes(child, nodes)
+			}
+		}
+	case *tparse.IfNode:
+		nodes = textNodes(n.List, textNodes(n.ElseList, nodes))
+	case *tparse.RangeNode:
+		nodes = textNodes(n.List, textNodes(n.ElseList, nodes))
+	case *tparse.WithNode:
+		nodes = textNodes(n.List, textNodes(n.ElseList, nodes))
+	}
+	return nodes
+}
+
+// literal returns text as template source that renders to text. It is
+// written out as it is unless it would start an action of its own, either
+// by containing {{ or, when an action follows, by ending in {.
+func literal(text string, beforeAction bool) string {
+	if strings.Contains(text, "{{") || beforeAction && strings.HasSuffix(text, "{") {
+		return "{{" + strconv.Quote(text) + "}}"
+	}
+	return text
+}
+
+// Render renders a command template against the event.
+func (e Event) Render(text string) (string, error) {
+	if !isTemplate(text) {
+		return text, nil
+	}
+	tmpl, err := parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, e); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// shellQuote wraps s in single quotes so the shell passes it through as one word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,106 @@
+package event
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	ev := Event{
+		Package: Package{Name: "app"},
+		Version: Version{ID: 7, SHA: "sha256:abc", Digest: "sha256:def"},
+		Tag:     "v1.4.0",
+		Sender:  "o'brien",
+	}
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"echo plain", "echo plain"},
+		{"docker tag {{.Package.Name}}@{{.Version.Digest}} {{.Package.Name}}:{{ .Tag | trimPrefix \"v\" }}", "docker tag app@sha256:def app:1.4.0"},
+		{"{{ .Tag | trimSuffix \".0\" }}", "v1.4"},
+		{"{{ .Version.SHA | replace \"sha256:\" \"\" }}", "abc"},
+		{"{{ .Package.Name | upper }} {{ \"ABC\" | lower }}", "APP abc"},
+		{"echo {{ .Sender | quote }}", `echo 'o'\''brien'`},
+		{"echo {{.Version.ID}}", "echo 7"},
+	}
+
+	for _, tt := range tests {
+		got, err := ev.Render(tt.text)
+		if err != nil {
+			t.Errorf("Render(%q) returned error: %v", tt.text, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Render(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		text    string
+		wantErr string
+	}{
+		{"echo {{.Package.Name}}", ""},
+		{"awk '{print $1}'", ""},
+		{"echo {{.Pkg}}", "can't evaluate field Pkg"},
+		{"echo {{.Outputs.IMAGE}}", ""},
+		{"echo {{.Outputs.OTHER}}", `map has no entry for key "OTHER"`},
+		{"echo {{.Package.Nme}}", "can't evaluate field Nme"},
+		{"echo {{ .Tag | nope }}", `function "nope" not defined`},
+		{"echo {{ .Tag ", "unclosed action"},
+	}
+
+	for _, tt := range tests {
+		err := Check(tt.text, []string{"IMAGE"})
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("Check(%q) returned error: %v", tt.text, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("Check(%q) = %v, want error containing %q", tt.text, err, tt.wantErr)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	values := strings.NewReplacer("${TOK}", "ab{{.Tag}}cd", "${OPEN}", "x{", "${PLAIN}", "plain")
+	expand := func(s string) (string, error) { return values.Replace(s), nil }
+	ev := Event{Tag: "v1"}
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"echo ${PLAIN}", "echo plain"},
+		{"echo ${TOK}", "echo ab{{.Tag}}cd"},
+		{"echo ${TOK} {{.Tag}}", "echo ab{{.Tag}}cd v1"},
+		{"echo ${OPEN}{{.Tag}}", "echo x{v1"},
+		{"{{if .Tag}}${TOK}{{else}}${PLAIN}{{end}}", "ab{{.Tag}}cd"},
+		{`{{ "${TOK}" }}`, "${TOK}"},
+	}
+
+	for _, tt := range tests {
+		expanded, err := Expand(tt.text, expand)
+		if err != nil {
+			t.Errorf("Expand(%q) returned error: %v", tt.text, err)
+			continue
+		}
+		got, err := ev.Render(expanded)
+		if err != nil {
+			t.Errorf("Render(%q) returned error: %v", expanded, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Expand(%q) renders to %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	if got, _ := Expand("echo ${PLAIN}", expand); got != "echo plain" {
+		t.Errorf("expected text that can't start an action to be left as it is, got %q", got)
+	}
+	if got, _ := Expand("echo {{ .Tag ${TOK}", expand); got != "echo {{ .Tag ${TOK}" {
+		t.Errorf("expected a template that doesn't parse to be returned as written, got %q", got)
+	}
+}
@@ -0,0 +1,28 @@
+package executor
+
+import (
+	"fmt"
+	"log"
+)
+
+// DryRunner logs the program each command would run, where and as whom,
+// instead of running it. Every command succeeds without output. A command
+// with Options.Shown set is logged as that, to keep expanded secrets out.
+type DryRunner struct{}
+
+func (DryRunner) Run(cmd string, dir string, opts Options) (string, error) {
+	where := dir
+	if where == "" {
+		where = "the current directory"
+	}
+	as := ""
+	if opts.Credential != nil {
+		as = fmt.Sprintf(" as uid %d gid %d", opts.Credential.Uid, opts.Credential.Gid)
+	}
+	what := fmt.Sprintf("%q", commandLine(cmd, opts))
+	if opts.Shown != "" {
+		what = fmt.Sprintf("%q", opts.Shown)
+	}
+	log.Printf("[dry run] would run %s in %s%s", what, where, as)
+	return "", nil
+}
@@ -0,0 +1,112 @@
+package executor
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jc/steakpie/internal/config"
+)
+
+// environ is a command's environment, by variable name.
+type environ map[string]string
+
+// baseEnv returns the part of steakpie's own environment that commands
+// inherit: all of it, or with clean set only the variables named in allow.
+func baseEnv(clean bool, allow []string) environ {
+	env := make(environ)
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		env[name] = value
+	}
+	if clean {
+		allowed := make(environ, len(allow))
+		for _, name := range allow {
+			if value, ok := env[name]; ok {
+				allowed[name] = value
+			}
+		}
+		env = allowed
+	}
+	return env
+}
+
+// apply reads the env files in settings, then sets its variables, which win
+// over anything the files set. Relative file paths are taken from dir.
+func (e environ) apply(settings config.Env, dir string) error {
+	for _, file := range settings.Files {
+		if !filepath.IsAbs(file) && dir != "" {
+			file = filepath.Join(dir, file)
+		}
+		vars, err := readEnvFile(file)
+		if err != nil {
+			return err
+		}
+		for name, value := range vars {
+			e[name] = value
+		}
+	}
+	for name, value := range settings.Vars {
+		e[name] = value
+	}
+	return nil
+}
+
+// set adds variables given in KEY=value form.
+func (e environ) set(vars []string) {
+	for _, kv := range vars {
+		name, value, _ := strings.Cut(kv, "=")
+		e[name] = value
+	}
+}
+
+// list returns the environment in KEY=value form, sorted by name, without secrets.
+func (e environ) list() []string {
+	for _, name := range config.SecretVars {
+		delete(e, name)
+	}
+	list := make([]string, 0, len(e))
+	for name, value := range e {
+		list = append(list, name+"="+value)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// readEnvFile parses a dotenv file: KEY=value lines, optionally starting with
+// "export ", with blank lines and # comments ignored. Values may be wrapped in
+// single or double quotes.
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("env file: %w", err)
+	}
+	defer f.Close()
+
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("env file %s:%d: expected KEY=value", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("env file %s: %w", path, err)
+	}
+	return vars, nil
+}
@@ -0,0 +1,145 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jc/steakpie/internal/config"
+	"github.com/jc/steakpie/internal/event"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	content := `# comment
+
+PLAIN=value
+export EXPORTED=yes
+SPACED = padded
+DOUBLE="a b"
+SINGLE='c d'
+EMPTY=
+URL=postgres://u:p@host/db?x=1
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	vars, err := readEnvFile(path)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	expected := map[string]string{
+		"PLAIN":    "value",
+		"EXPORTED": "yes",
+		"SPACED":   "padded",
+		"DOUBLE":   "a b",
+		"SINGLE":   "c d",
+		"EMPTY":    "",
+		"URL":      "postgres://u:p@host/db?x=1",
+	}
+	if len(vars) != len(expected) {
+		t.Errorf("expected %d variables, got %v", len(expected), vars)
+	}
+	for name, want := range expected {
+		if got := vars[name]; got != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestReadEnvFile_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("GOOD=1\nnot a variable\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := readEnvFile(path)
+	if err == nil || !strings.Contains(err.Error(), ":2: expected KEY=value") {
+		t.Errorf("expected an error naming line 2, got: %v", err)
+	}
+}
+
+func TestExecute_EnvLayers(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte("FROM_FILE=dir\nLAYER=dir-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("WEBHOOK_SECRET", "do-not-leak")
+	t.Setenv("WEBHOOK_SECRET_FILE", "/etc/steakpie/secret")
+	t.Setenv("INHERITED", "yes")
+
+	runner := NewMockRunner()
+	pkg := config.PackageConfig{
+		Env: config.Env{Vars: map[string]string{"LAYER": "package", "PKG_ONLY": "1"}},
+		Run: config.Directories{{
+			Dir: dir,
+			Env: config.Env{Files: config.Strings{".env"}, Vars: map[string]string{"WEBHOOK_SECRET": "nope"}},
+			Commands: []config.Command{
+				{Cmd: "first", Env: config.Env{Vars: map[string]string{"LAYER": "command", "STEAKPIE_TAG": "spoofed"}}},
+			},
+		}},
+	}
+
+	Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, Tag: "latest", DeliveryID: "d-800"}, pkg)
+
+	if len(runner.Opts) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(runner.Opts))
+	}
+	env := runner.Opts[0].Env
+	for _, want := range []string{"INHERITED=yes", "PKG_ONLY=1", "FROM_FILE=dir", "LAYER=command", "STEAKPIE_TAG=latest"} {
+		if !slices.Contains(env, want) {
+			t.Errorf("expected env to contain %s, got %v", want, env)
+		}
+	}
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "WEBHOOK_SECRET=") || strings.HasPrefix(kv, "WEBHOOK_SECRET_FILE=") {
+			t.Errorf("expected WEBHOOK_SECRET and WEBHOOK_SECRET_FILE to be removed, got %s", kv)
+		}
+	}
+}
+
+func TestExecute_CleanEnv(t *testing.T) {
+	t.Setenv("WEBHOOK_SECRET", "do-not-leak")
+	t.Setenv("KEEP_ME", "kept")
+	t.Setenv("DROP_ME", "dropped")
+
+	runner := NewMockRunner()
+	pkg := dirCommands("/opt/test", []config.Command{{Cmd: "deploy"}})
+	pkg.CleanEnv = true
+	pkg.AllowEnv = []string{"KEEP_ME", "WEBHOOK_SECRET", "NOT_SET"}
+
+	Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-801"}, pkg)
+
+	env := runner.Opts[0].Env
+	if !slices.Contains(env, "KEEP_ME=kept") {
+		t.Errorf("expected allowed variable to be kept, got %v", env)
+	}
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if name != "KEEP_ME" && !strings.HasPrefix(name, "STEAKPIE_") {
+			t.Errorf("expected only allowed and event variables, got %s", kv)
+		}
+	}
+}
+
+func TestExecute_MissingEnvFileFailsCommand(t *testing.T) {
+	runner := NewMockRunner()
+	pkg := dirCommands(t.TempDir(), []config.Command{
+		{Cmd: "deploy", Env: config.Env{Files: config.Strings{"missing.env"}}},
+	})
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-802"}, pkg)
+	})
+
+	if len(runner.Commands) != 0 {
+		t.Errorf("expected nothing to run, got %v", runner.Commands)
+	}
+	if !strings.Contains(output, "command 1 of 1 failed: env file") {
+		t.Errorf("expected env file failure to be logged, got:\n%s", output)
+	}
+}
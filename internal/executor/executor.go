@@ -1,64 +1,476 @@
 package executor
 
 import (
+	"errors"
+	"fmt"
 	"log"
-	"os/exec"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/jc/steakpie/internal/config"
+	"github.com/jc/steakpie/internal/event"
 )
 
-// Runner executes a shell command in a given directory, returns combined stdout+stderr and error.
-type Runner interface {
-	Run(cmd string, dir string) (output string, err error)
+// Execute runs the commands configured for a package in response to a webhook event.
+// Setup commands run first and must all succeed before the run phase starts.
+// Teardown commands always run last, regardless of how setup and run went.
+// Within each phase, directories start in config order and, like sibling commands, run
+// concurrently, limited by the package's max_parallel setting. A directory waits for
+// the directories it needs. Children only run if their parent succeeds.
+// Every command sees the event as STEAKPIE_* environment variables, and the raw
+// payload in the file named by STEAKPIE_PAYLOAD_FILE (and on stdin with payload_stdin),
+// on top of steakpie's environment and the package, directory and command env settings.
+func Execute(runner Runner, ev event.Event, pkg config.PackageConfig) {
+	packageName, deliveryID := ev.Package.Name, ev.DeliveryID
+	log.Printf("start webhook for %s received with id: %s", packageName, deliveryID)
+
+	ex := &execution{
+		runner: runner,
+		event:  ev,
+		base:   baseEnv(pkg.CleanEnv, pkg.AllowEnv),
+		env:    pkg.Env,
+		extra:  ev.Env(),
+		shell:  pkg.Shell,
+	}
+	if pkg.MaxParallel > 0 {
+		ex.sem = make(chan struct{}, pkg.MaxParallel)
+	}
+	if len(ev.Payload) > 0 {
+		path, err := writePayload(ev.Payload, switchesUser(pkg))
+		if err != nil {
+			log.Printf("failed to write payload file for %s: %v", packageName, err)
+		} else {
+			defer os.Remove(path)
+			ex.extra = append(ex.extra, "STEAKPIE_PAYLOAD_FILE="+path)
+		}
+		if pkg.PayloadStdin {
+			ex.stdin = ev.Payload
+		}
+	}
+
+	if ex.executePhase("setup", pkg.Setup) {
+		ex.executePhase("run", pkg.Run)
+	} else {
+		log.Printf("setup failed for %s, skipping run", packageName)
+	}
+	ex.executePhase("teardown", pkg.Teardown)
+
+	log.Printf("end webhook for %s with id: %s", packageName, deliveryID)
 }
 
-// ShellRunner runs commands via bash -lc (login shell for env vars).
-type ShellRunner struct{}
+// execution holds the state shared by every command of a single Execute call.
+type execution struct {
+	runner Runner
+	event  event.Event
+	sem    chan struct{} // limits concurrent commands; nil means unlimited
+	base   environ       // the part of steakpie's environment commands inherit
+	env    config.Env    // the package's environment settings
+	extra  []string      // event variables, added to every command's environment last
+	stdin  []byte        // fed to every command, if set
+	shell  config.Shell  // runs command strings; nil means the runner's default
+}
 
-func (s ShellRunner) Run(cmd string, dir string) (string, error) {
-	c := exec.Command("bash", "-lc", cmd)
-	if dir != "" {
-		c.Dir = dir
+// writePayload saves the payload to a temporary file that only the current user can
+// read, unless shared is set because commands run as other users.
+func writePayload(payload []byte, shared bool) (string, error) {
+	f, err := os.CreateTemp("", "steakpie-payload-*.json")
+	if err != nil {
+		return "", err
 	}
-	out, err := c.CombinedOutput()
-	return string(out), err
+	if shared {
+		err = f.Chmod(0644)
+	}
+	if err == nil {
+		_, err = f.Write(payload)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
 }
 
-// Execute runs commands for a webhook event, grouped by directory.
-// Each directory's commands run sequentially. Children only run if their parent succeeds.
-func Execute(runner Runner, packageName, deliveryID string, dirCommands map[string][]config.Command) {
-	log.Printf("start webhook for %s received with id: %s", packageName, deliveryID)
+// switchesUser reports whether any directory of the package runs as a configured user.
+func switchesUser(pkg config.PackageConfig) bool {
+	for _, dirs := range []config.Directories{pkg.Setup, pkg.Run, pkg.Teardown} {
+		for _, d := range dirs {
+			if d.RunAs != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// logMu keeps the log lines of a single command together when commands run concurrently.
+var logMu sync.Mutex
 
-	for dir, commands := range dirCommands {
-		log.Printf("executing in directory: %s", dir)
-		executeLevel(runner, dir, commands)
+// logGroup writes lines to the log without lines from other commands in between.
+func logGroup(lines ...string) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	for _, line := range lines {
+		log.Print(line)
 	}
+}
 
-	log.Printf("end webhook for %s with id: %s", packageName, deliveryID)
+// executePhase runs every directory of a phase and reports whether all of its commands succeeded.
+// Directories start in config order and run concurrently, except that a directory
+// waits for the directories it needs and is skipped if any of them failed.
+func (ex *execution) executePhase(phase string, dirs config.Directories) bool {
+	type dirState struct {
+		done chan struct{}
+		ok   bool
+	}
+	states := make(map[string]*dirState, len(dirs))
+	for _, d := range dirs {
+		states[d.Dir] = &dirState{done: make(chan struct{})}
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range dirs {
+		st := states[d.Dir]
+		if len(d.Needs) == 0 {
+			log.Printf("%s: executing in directory: %s", phase, d.Dir)
+		} else {
+			log.Printf("%s: directory %s waiting for %s", phase, d.Dir, strings.Join(d.Needs, ", "))
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(st.done)
+			for _, need := range d.Needs {
+				dep, known := states[need]
+				if known {
+					<-dep.done
+				}
+				if !known || !dep.ok {
+					log.Printf("%s: skipping directory %s because %s did not succeed", phase, d.Dir, need)
+					return
+				}
+			}
+			if len(d.Needs) > 0 {
+				log.Printf("%s: executing in directory: %s", phase, d.Dir)
+			}
+			st.ok = ex.executeLevel(d, d.Commands, nil)
+		}()
+	}
+	wg.Wait()
+
+	for _, st := range states {
+		if !st.ok {
+			return false
+		}
+	}
+	return true
 }
 
-// executeLevel runs a slice of sibling commands. Siblings continue even if one fails.
-// Children of a command only run if the parent succeeds.
-func executeLevel(runner Runner, dir string, commands []config.Command) {
+// executeLevel runs a slice of sibling commands concurrently. Siblings continue even if one fails.
+// Children of a command only run if the parent succeeds, its on_failure commands only if
+// it fails, and its always commands afterwards either way.
+// A command waits for the steps it needs and is skipped if any of them fail, including their
+// branches. It also waits for earlier siblings that capture output, and sees the outputs in
+// scope plus everything those siblings saw or captured. A command's branches also see its own output.
+// Returns false if any command at this level failed or was skipped, or any children or always
+// commands below it.
+func (ex *execution) executeLevel(d config.Directory, commands []config.Command, scope outputs) bool {
+	type step struct {
+		ran   chan struct{} // closed once the command itself has finished
+		done  chan struct{} // closed once its branches have finished too
+		ok    bool
+		scope outputs // what the command saw, plus its own output
+	}
+	steps := make([]*step, len(commands))
+	for i := range commands {
+		steps[i] = &step{ran: make(chan struct{}), done: make(chan struct{})}
+	}
+
+	var wg sync.WaitGroup
 	total := len(commands)
 	for i, cmd := range commands {
-		n := i + 1
-		log.Printf("running command %d of %d: %s", n, total, cmd.Cmd)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st := steps[i]
+			defer close(st.done)
+			ran := sync.OnceFunc(func() { close(st.ran) })
+			defer ran()
+
+			scope := scope
+			for _, j := range config.StepDeps(commands, i) {
+				dep := steps[j]
+				if slices.Contains(cmd.Needs, commands[j].Name) {
+					<-dep.done
+					if !dep.ok {
+						log.Printf("[%s] skipping command %d of %d because %s did not succeed", d.Dir, i+1, total, commands[j].Name)
+						st.scope = scope
+						return
+					}
+				} else {
+					<-dep.ran
+				}
+				scope = scope.merge(dep.scope)
+			}
+
+			ok, stdout := ex.executeCommand(d, i+1, total, cmd, scope)
+			if ok && cmd.Capture != "" {
+				scope = scope.with(cmd.Capture, stdout)
+			}
+			st.scope = scope
+			ran()
 
-		output, err := runner.Run(cmd.Cmd, dir)
-		if output != "" {
-			log.Printf("output: %s", output)
+			switch {
+			case ok && len(cmd.Children) > 0:
+				ok = ex.executeLevel(d, cmd.Children, scope)
+			case !ok && len(cmd.OnFailure) > 0:
+				log.Printf("[%s] command %d of %d failed, running on_failure commands", d.Dir, i+1, total)
+				ex.executeLevel(d, cmd.OnFailure, scope)
+			}
+			if len(cmd.Always) > 0 {
+				log.Printf("[%s] command %d of %d finished, running always commands", d.Dir, i+1, total)
+				if !ex.executeLevel(d, cmd.Always, scope) {
+					ok = false
+				}
+			}
+			st.ok = ok
+		}()
+	}
+	wg.Wait()
+
+	for _, st := range steps {
+		if !st.ok {
+			return false
 		}
+	}
+	return true
+}
 
-		if err != nil {
-			log.Printf("command %d of %d failed: %v", n, total, err)
-			continue
+// executeCommand renders a single command against the event and runs it, retrying
+// with exponential backoff if it fails and the command allows retries.
+// Reports whether any attempt succeeded, and the trimmed stdout of that attempt when
+// the command captures it. A command that doesn't render never runs.
+func (ex *execution) executeCommand(d config.Directory, n, total int, cmd config.Command, scope outputs) (bool, string) {
+	cmd, err := ex.prepare(cmd, scope)
+	if err != nil {
+		log.Printf("[%s] command %d of %d failed: %v", d.Dir, n, total, err)
+		return false, ""
+	}
+	opts, err := ex.commandOptions(d, cmd, scope)
+	if err != nil {
+		log.Printf("[%s] command %d of %d failed: %v", d.Dir, n, total, err)
+		return false, ""
+	}
+
+	attempts := cmd.Retries + 1
+	for attempt := 1; ; attempt++ {
+		label := fmt.Sprintf("command %d of %d", n, total)
+		if attempts > 1 {
+			label += fmt.Sprintf(" (attempt %d of %d)", attempt, attempts)
+		}
+
+		if ok, stdout := ex.runAttempt(d.Dir, label, cmd, opts); ok {
+			return true, stdout
+		}
+		if attempt == attempts {
+			return false, ""
+		}
+
+		delay := retryDelay(cmd.Backoff, attempt)
+		log.Printf("[%s] retrying %s in %s", d.Dir, cmd.Shown(), delay)
+		time.Sleep(delay)
+	}
+}
+
+// prepare renders the command's templates against the event and, when it
+// won't go through a shell, works out the program and arguments to run.
+// The command as written is rendered too, for the log, and errors quote it
+// rather than the text that runs.
+func (ex *execution) prepare(cmd config.Command, scope outputs) (config.Command, error) {
+	ev := ex.event
+	ev.Outputs = scope
+	if cmd.Raw != "" {
+		if raw, err := ev.Render(cmd.Raw); err == nil {
+			cmd.Raw = raw
 		}
+	}
+	if cmd.Argv != nil {
+		argv := make([]string, len(cmd.Argv))
+		for i, arg := range cmd.Argv {
+			rendered, err := ev.Render(arg)
+			if err != nil {
+				return cmd, fmt.Errorf("cannot render %q: %w", cmd.Shown(), err)
+			}
+			argv[i] = rendered
+		}
+		cmd.Argv = argv
+		cmd.Cmd = strings.Join(argv, " ")
+		return cmd, nil
+	}
 
-		log.Printf("command %d of %d succeeded", n, total)
+	rendered, err := ev.Render(cmd.Cmd)
+	if err != nil {
+		return cmd, fmt.Errorf("cannot render %q: %w", cmd.Shown(), err)
+	}
+	cmd.Cmd = rendered
 
-		if len(cmd.Children) > 0 {
-			executeLevel(runner, dir, cmd.Children)
+	if ex.shell.None() {
+		if cmd.Argv, err = splitWords(rendered); err != nil {
+			return cmd, fmt.Errorf("cannot split %q: %w", cmd.Shown(), err)
+		}
+		if len(cmd.Argv) == 0 {
+			return cmd, fmt.Errorf("command is empty")
 		}
 	}
+	return cmd, nil
+}
+
+// runAttempt runs a command once a parallel slot is free and logs its outcome.
+// It returns whether the command succeeded and, if it captures output, its trimmed stdout.
+func (ex *execution) runAttempt(dir, label string, cmd config.Command, opts Options) (bool, string) {
+	if ex.sem != nil {
+		ex.sem <- struct{}{}
+		defer func() { <-ex.sem }()
+	}
+
+	log.Printf("[%s] running %s: %s", dir, label, cmd.Shown())
+
+	var stdout strings.Builder
+	if cmd.Capture != "" {
+		opts.Stdout = &stdout
+	}
+	opts.Shown = cmd.Raw
+	output, err := ex.runner.Run(cmd.Cmd, dir, opts)
+
+	var lines []string
+	if output != "" {
+		lines = append(lines, fmt.Sprintf("[%s] output: %s", dir, output))
+	}
+	ok, result := outcome(cmd, output, err)
+	lines = append(lines, fmt.Sprintf("[%s] %s %s", dir, label, result))
+	if ok && cmd.Capture != "" {
+		lines = append(lines, fmt.Sprintf("[%s] captured output as %s", dir, cmd.Capture))
+	}
+	logGroup(lines...)
+
+	return ok, strings.TrimSpace(stdout.String())
+}
+
+// outcome applies the command's success rules to a finished run. It reports
+// whether the command succeeded and describes the result for the log.
+// Timeouts always count as failures.
+func outcome(cmd config.Command, output string, err error) (bool, string) {
+	var timeout *TimeoutError
+	switch {
+	case errors.As(err, &timeout):
+		return false, fmt.Sprintf("timed out: %v", err)
+	case cmd.FailIfOutputMatches != nil && cmd.FailIfOutputMatches.MatchString(output):
+		return false, fmt.Sprintf("failed: output matches %s", cmd.FailIfOutputMatches)
+	case cmd.SucceedIfOutputMatches != nil && cmd.SucceedIfOutputMatches.MatchString(output):
+		return true, fmt.Sprintf("succeeded: output matches %s", cmd.SucceedIfOutputMatches)
+	}
+
+	allowed := []int{0}
+	if len(cmd.SuccessExitCodes) > 0 {
+		allowed = cmd.SuccessExitCodes
+	}
+	code, exited := exitCode(err)
+	switch {
+	case exited && slices.Contains(allowed, code) && code == 0:
+		return true, "succeeded"
+	case exited && slices.Contains(allowed, code):
+		return true, fmt.Sprintf("succeeded: exit status %d is allowed", code)
+	case err != nil:
+		return false, fmt.Sprintf("failed: %v", err)
+	default:
+		return false, "failed: exit status 0 is not in success_exit_codes"
+	}
+}
+
+// exitCode returns the exit status of a command that ran to completion.
+// The boolean is false if the command didn't get as far as exiting.
+func exitCode(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode(), true
+	}
+	return 0, false
+}
+
+// defaultBackoff is the wait before the first retry when a command doesn't set one.
+const defaultBackoff = 2 * time.Second
+
+// maxBackoff caps the doubling wait between retries. A base backoff longer
+// than this is used as it is, without doubling.
+const maxBackoff = 5 * time.Minute
+
+// retryDelay returns how long to wait after the given failed attempt.
+// The delay starts at base and doubles with every attempt, up to maxBackoff.
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		base = defaultBackoff
+	}
+	if base >= maxBackoff {
+		return base
+	}
+	delay := base
+	for range attempt - 1 {
+		if delay *= 2; delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
+
+// commandOptions resolves the runner options for cmd, falling back to the directory's settings.
+// The environment layers the package's, directory's and command's env settings, in that order,
+// followed by the event and the outputs in scope.
+// Commands running as a configured user get that user's HOME, USER and LOGNAME underneath.
+func (ex *execution) commandOptions(d config.Directory, cmd config.Command, scope outputs) (Options, error) {
+	env := maps.Clone(ex.base)
+	if d.RunAs != nil {
+		env["HOME"], env["USER"], env["LOGNAME"] = d.RunAs.Home, d.RunAs.Name, d.RunAs.Name
+	}
+	for _, layer := range []config.Env{ex.env, d.Env, cmd.Env} {
+		if err := env.apply(layer, d.Dir); err != nil {
+			return Options{}, err
+		}
+	}
+	env.set(ex.extra)
+	for name, value := range scope {
+		env[outputVarPrefix+name] = value
+	}
+
+	opts := Options{
+		Timeout:     d.Timeout,
+		IdleTimeout: d.IdleTimeout,
+		Env:         env.list(),
+		Stdin:       ex.stdin,
+		Argv:        cmd.Argv,
+	}
+	if d.RunAs != nil {
+		opts.Credential = &syscall.Credential{Uid: d.RunAs.Uid, Gid: d.RunAs.Gid, Groups: d.RunAs.Groups}
+	}
+	if cmd.Argv == nil {
+		opts.Shell = ex.shell
+	}
+	if cmd.Timeout > 0 {
+		opts.Timeout = cmd.Timeout
+	}
+	if cmd.IdleTimeout > 0 {
+		opts.IdleTimeout = cmd.IdleTimeout
+	}
+	return opts, nil
 }
@@ -3,19 +3,29 @@ package executor
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/jc/steakpie/internal/config"
+	"github.com/jc/steakpie/internal/event"
 )
 
 // MockRunner records commands and returns preset results.
+// It is safe for concurrent use.
 type MockRunner struct {
+	mu       sync.Mutex
 	Commands []string
 	Dirs     []string
+	Opts     []Options
 	Results  map[string]struct {
 		Output string
 		Err    error
@@ -38,10 +48,16 @@ func (m *MockRunner) SetResult(cmd, output string, err error) {
 	}{output, err}
 }
 
-func (m *MockRunner) Run(cmd string, dir string) (string, error) {
+func (m *MockRunner) Run(cmd string, dir string, opts Options) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.Commands = append(m.Commands, cmd)
 	m.Dirs = append(m.Dirs, dir)
+	m.Opts = append(m.Opts, opts)
 	if r, ok := m.Results[cmd]; ok {
+		if opts.Stdout != nil {
+			io.WriteString(opts.Stdout, r.Output)
+		}
 		return r.Output, r.Err
 	}
 	return "", nil
@@ -53,16 +69,26 @@ func captureLog(f func()) string {
 	log.SetOutput(&buf)
 	log.SetFlags(0)
 	defer func() {
-		log.SetOutput(nil)
+		log.SetOutput(os.Stderr)
 		log.SetFlags(log.LstdFlags)
 	}()
 	f()
 	return buf.String()
 }
 
-// helper to wrap commands in a single-directory map
-func dirCommands(dir string, commands []config.Command) map[string][]config.Command {
-	return map[string][]config.Command{dir: commands}
+// assertRanUnordered checks that exactly the expected commands ran, in any order.
+func assertRanUnordered(t *testing.T, runner *MockRunner, expected ...string) {
+	t.Helper()
+	got := slices.Sorted(slices.Values(runner.Commands))
+	want := slices.Sorted(slices.Values(expected))
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected commands %v, got %v", want, runner.Commands)
+	}
+}
+
+// helper to wrap commands in a package with a single run directory
+func dirCommands(dir string, commands []config.Command) config.PackageConfig {
+	return config.PackageConfig{Run: config.Directories{{Dir: dir, Commands: commands}}}
 }
 
 func TestParallelFlow_FirstFails_SecondStillRuns(t *testing.T) {
@@ -75,17 +101,9 @@ func TestParallelFlow_FirstFails_SecondStillRuns(t *testing.T) {
 		{Cmd: "cmd2"},
 	})
 
-	Execute(runner, "test-pkg", "delivery-1", commands)
+	Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-1"}, commands)
 
-	if len(runner.Commands) != 2 {
-		t.Fatalf("expected 2 commands to run, got %d", len(runner.Commands))
-	}
-	if runner.Commands[0] != "cmd1" {
-		t.Errorf("expected first command 'cmd1', got '%s'", runner.Commands[0])
-	}
-	if runner.Commands[1] != "cmd2" {
-		t.Errorf("expected second command 'cmd2', got '%s'", runner.Commands[1])
-	}
+	assertRanUnordered(t, runner, "cmd1", "cmd2")
 }
 
 func TestNestedFlow_ParentFails_ChildSkipped(t *testing.T) {
@@ -98,7 +116,7 @@ func TestNestedFlow_ParentFails_ChildSkipped(t *testing.T) {
 		}},
 	})
 
-	Execute(runner, "test-pkg", "delivery-2", commands)
+	Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-2"}, commands)
 
 	if len(runner.Commands) != 1 {
 		t.Fatalf("expected 1 command to run (child skipped), got %d: %v", len(runner.Commands), runner.Commands)
@@ -117,7 +135,7 @@ func TestNestedFlow_ParentSucceeds_ChildRuns(t *testing.T) {
 		}},
 	})
 
-	Execute(runner, "test-pkg", "delivery-3", commands)
+	Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-3"}, commands)
 
 	if len(runner.Commands) != 2 {
 		t.Fatalf("expected 2 commands to run, got %d", len(runner.Commands))
@@ -141,17 +159,9 @@ func TestMixedFlow_ParentFails_ChildSkipped_SiblingRuns(t *testing.T) {
 		{Cmd: "sibling"},
 	})
 
-	Execute(runner, "test-pkg", "delivery-4", commands)
+	Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-4"}, commands)
 
-	if len(runner.Commands) != 2 {
-		t.Fatalf("expected 2 commands (parent+sibling, child skipped), got %d: %v", len(runner.Commands), runner.Commands)
-	}
-	if runner.Commands[0] != "parent" {
-		t.Errorf("expected first 'parent', got '%s'", runner.Commands[0])
-	}
-	if runner.Commands[1] != "sibling" {
-		t.Errorf("expected second 'sibling', got '%s'", runner.Commands[1])
-	}
+	assertRanUnordered(t, runner, "parent", "sibling")
 }
 
 func TestDeeplyNestedChain(t *testing.T) {
@@ -165,7 +175,7 @@ func TestDeeplyNestedChain(t *testing.T) {
 		}},
 	})
 
-	Execute(runner, "test-pkg", "delivery-5", commands)
+	Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-5"}, commands)
 
 	expected := []string{"l1", "l2", "l3"}
 	if len(runner.Commands) != len(expected) {
@@ -181,7 +191,7 @@ func TestDeeplyNestedChain(t *testing.T) {
 func TestEmptyCommandList(t *testing.T) {
 	runner := NewMockRunner()
 
-	Execute(runner, "test-pkg", "delivery-6", map[string][]config.Command{})
+	Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-6"}, config.PackageConfig{})
 
 	if len(runner.Commands) != 0 {
 		t.Errorf("expected no commands to run, got %d", len(runner.Commands))
@@ -191,13 +201,13 @@ func TestEmptyCommandList(t *testing.T) {
 func TestDirectoryPassedToRunner(t *testing.T) {
 	runner := NewMockRunner()
 
-	commands := map[string][]config.Command{
-		"/opt/myapp": {
+	commands := config.PackageConfig{Run: config.Directories{
+		{Dir: "/opt/myapp", Commands: []config.Command{
 			{Cmd: "echo hello"},
-		},
-	}
+		}},
+	}}
 
-	Execute(runner, "test-pkg", "delivery-7", commands)
+	Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-7"}, commands)
 
 	if len(runner.Dirs) != 1 {
 		t.Fatalf("expected 1 dir, got %d", len(runner.Dirs))
@@ -217,7 +227,7 @@ func TestLogOutput_ParallelFlow(t *testing.T) {
 	})
 
 	output := captureLog(func() {
-		Execute(runner, "mypkg", "d-123", commands)
+		Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-123"}, commands)
 	})
 
 	expectations := []string{
@@ -248,7 +258,7 @@ func TestLogOutput_NestedFlow(t *testing.T) {
 	})
 
 	output := captureLog(func() {
-		Execute(runner, "mypkg", "d-456", commands)
+		Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-456"}, commands)
 	})
 
 	if !strings.Contains(output, "executing in directory: /opt/test") {
@@ -265,7 +275,7 @@ func TestLogOutput_FailedCommand(t *testing.T) {
 	})
 
 	output := captureLog(func() {
-		Execute(runner, "mypkg", "d-789", commands)
+		Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-789"}, commands)
 	})
 
 	if !strings.Contains(output, "command 1 of 1 failed") {
@@ -273,10 +283,402 @@ func TestLogOutput_FailedCommand(t *testing.T) {
 	}
 }
 
+func TestSetupSucceeds_RunAndTeardownExecute(t *testing.T) {
+	runner := NewMockRunner()
+
+	pkg := config.PackageConfig{
+		Setup:    config.Directories{{Dir: "/opt/test", Commands: []config.Command{{Cmd: "snapshot"}}}},
+		Run:      config.Directories{{Dir: "/opt/test", Commands: []config.Command{{Cmd: "deploy"}}}},
+		Teardown: config.Directories{{Dir: "/opt/test", Commands: []config.Command{{Cmd: "cleanup"}}}},
+	}
+
+	Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-8"}, pkg)
+
+	expected := []string{"snapshot", "deploy", "cleanup"}
+	if len(runner.Commands) != len(expected) {
+		t.Fatalf("expected %d commands, got %d: %v", len(expected), len(runner.Commands), runner.Commands)
+	}
+	for i, exp := range expected {
+		if runner.Commands[i] != exp {
+			t.Errorf("command %d: expected '%s', got '%s'", i, exp, runner.Commands[i])
+		}
+	}
+}
+
+func TestSetupFails_RunSkipped_TeardownRuns(t *testing.T) {
+	runner := NewMockRunner()
+	runner.SetResult("snapshot", "", fmt.Errorf("exit status 1"))
+
+	pkg := config.PackageConfig{
+		Setup:    config.Directories{{Dir: "/opt/test", Commands: []config.Command{{Cmd: "snapshot"}, {Cmd: "prepare"}}}},
+		Run:      config.Directories{{Dir: "/opt/test", Commands: []config.Command{{Cmd: "deploy"}}}},
+		Teardown: config.Directories{{Dir: "/opt/test", Commands: []config.Command{{Cmd: "cleanup"}}}},
+	}
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-9"}, pkg)
+	})
+
+	assertRanUnordered(t, runner, "snapshot", "prepare", "cleanup")
+	if runner.Commands[2] != "cleanup" {
+		t.Errorf("expected teardown to run last, got %v", runner.Commands)
+	}
+	if !strings.Contains(output, "setup failed for test-pkg, skipping run") {
+		t.Errorf("expected setup failure log, got:\n%s", output)
+	}
+}
+
+func TestSetupChildFails_RunSkipped(t *testing.T) {
+	runner := NewMockRunner()
+	runner.SetResult("child", "", fmt.Errorf("exit status 1"))
+
+	pkg := config.PackageConfig{
+		Setup: config.Directories{{Dir: "/opt/test", Commands: []config.Command{
+			{Cmd: "parent", Children: []config.Command{{Cmd: "child"}}},
+		}}},
+		Run: config.Directories{{Dir: "/opt/test", Commands: []config.Command{{Cmd: "deploy"}}}},
+	}
+
+	Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-10"}, pkg)
+
+	for _, cmd := range runner.Commands {
+		if cmd == "deploy" {
+			t.Fatalf("expected run to be skipped after setup child failure, got: %v", runner.Commands)
+		}
+	}
+}
+
+func TestRunFails_TeardownStillRuns(t *testing.T) {
+	runner := NewMockRunner()
+	runner.SetResult("deploy", "", fmt.Errorf("exit status 1"))
+
+	pkg := config.PackageConfig{
+		Run:      config.Directories{{Dir: "/opt/test", Commands: []config.Command{{Cmd: "deploy"}}}},
+		Teardown: config.Directories{{Dir: "/tmp", Commands: []config.Command{{Cmd: "cleanup"}}}},
+	}
+
+	Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-11"}, pkg)
+
+	if len(runner.Commands) != 2 || runner.Commands[1] != "cleanup" {
+		t.Fatalf("expected teardown to run after failed run, got: %v", runner.Commands)
+	}
+	if runner.Dirs[1] != "/tmp" {
+		t.Errorf("expected teardown dir '/tmp', got '%s'", runner.Dirs[1])
+	}
+}
+
+// blockingRunner tracks how many commands are running at once.
+// Each command holds its slot until release is closed or hold elapses.
+type blockingRunner struct {
+	running atomic.Int32
+	peak    atomic.Int32
+	hold    time.Duration
+}
+
+func (b *blockingRunner) Run(cmd string, dir string, opts Options) (string, error) {
+	n := b.running.Add(1)
+	defer b.running.Add(-1)
+	for {
+		p := b.peak.Load()
+		if n <= p || b.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	time.Sleep(b.hold)
+	return "", nil
+}
+
+func TestSiblingsRunConcurrently(t *testing.T) {
+	runner := &blockingRunner{hold: 50 * time.Millisecond}
+
+	pkg := config.PackageConfig{Run: config.Directories{
+		{Dir: "/opt/a", Commands: []config.Command{{Cmd: "a1"}, {Cmd: "a2"}, {Cmd: "a3"}}},
+		{Dir: "/opt/b", Commands: []config.Command{{Cmd: "b1"}}},
+	}}
+
+	Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-12"}, pkg)
+
+	if peak := runner.peak.Load(); peak != 4 {
+		t.Errorf("expected all 4 commands to run at once, peak was %d", peak)
+	}
+}
+
+func TestMaxParallelLimitsConcurrency(t *testing.T) {
+	runner := &blockingRunner{hold: 10 * time.Millisecond}
+
+	pkg := config.PackageConfig{
+		MaxParallel: 2,
+		Run: config.Directories{
+			{Dir: "/opt/a", Commands: []config.Command{
+				{Cmd: "a1", Children: []config.Command{{Cmd: "a1.1"}, {Cmd: "a1.2"}}},
+				{Cmd: "a2"},
+				{Cmd: "a3"},
+			}},
+			{Dir: "/opt/b", Commands: []config.Command{{Cmd: "b1"}, {Cmd: "b2"}}},
+		},
+	}
+
+	Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-13"}, pkg)
+
+	if peak := runner.peak.Load(); peak != 2 {
+		t.Errorf("expected at most 2 commands at once, peak was %d", peak)
+	}
+}
+
+func TestLogOutput_GroupedPerCommand(t *testing.T) {
+	runner := NewMockRunner()
+	runner.SetResult("cmd1", "one", nil)
+	runner.SetResult("cmd2", "two", fmt.Errorf("exit status 1"))
+
+	commands := dirCommands("/opt/test", []config.Command{
+		{Cmd: "cmd1"},
+		{Cmd: "cmd2"},
+	})
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-999"}, commands)
+	})
+
+	for _, group := range []string{
+		"[/opt/test] output: one\n[/opt/test] command 1 of 2 succeeded",
+		"[/opt/test] output: two\n[/opt/test] command 2 of 2 failed",
+	} {
+		if !strings.Contains(output, group) {
+			t.Errorf("expected log to contain grouped lines %q, got:\n%s", group, output)
+		}
+	}
+}
+
+func TestDirectoryNeeds_WaitsForDependency(t *testing.T) {
+	runner := NewMockRunner()
+
+	pkg := config.PackageConfig{Run: config.Directories{
+		{Dir: "/srv/api", Commands: []config.Command{
+			{Cmd: "migrate", Children: []config.Command{{Cmd: "seed"}}},
+		}},
+		{Dir: "/srv/web", Needs: []string{"/srv/api"}, Commands: []config.Command{{Cmd: "restart"}}},
+	}}
+
+	Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-14"}, pkg)
+
+	expected := []string{"migrate", "seed", "restart"}
+	if !slices.Equal(runner.Commands, expected) {
+		t.Fatalf("expected commands %v, got %v", expected, runner.Commands)
+	}
+}
+
+func TestDirectoryNeeds_SkippedWhenDependencyFails(t *testing.T) {
+	runner := NewMockRunner()
+	runner.SetResult("migrate", "", fmt.Errorf("exit status 1"))
+
+	pkg := config.PackageConfig{Run: config.Directories{
+		{Dir: "/srv/api", Commands: []config.Command{{Cmd: "migrate"}}},
+		{Dir: "/srv/web", Needs: []string{"/srv/api"}, Commands: []config.Command{{Cmd: "restart"}}},
+		{Dir: "/srv/docs", Commands: []config.Command{{Cmd: "rebuild"}}},
+	}}
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-15"}, pkg)
+	})
+
+	assertRanUnordered(t, runner, "migrate", "rebuild")
+	if !strings.Contains(output, "skipping directory /srv/web because /srv/api did not succeed") {
+		t.Errorf("expected skip log, got:\n%s", output)
+	}
+}
+
+func TestDirectoryStartOrderFollowsConfig(t *testing.T) {
+	runner := NewMockRunner()
+
+	pkg := config.PackageConfig{Run: config.Directories{
+		{Dir: "/srv/c", Commands: []config.Command{{Cmd: "c"}}},
+		{Dir: "/srv/a", Commands: []config.Command{{Cmd: "a"}}},
+		{Dir: "/srv/b", Commands: []config.Command{{Cmd: "b"}}},
+	}}
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-16"}, pkg)
+	})
+
+	c := strings.Index(output, "executing in directory: /srv/c")
+	a := strings.Index(output, "executing in directory: /srv/a")
+	b := strings.Index(output, "executing in directory: /srv/b")
+	if c < 0 || a < 0 || b < 0 || !(c < a && a < b) {
+		t.Errorf("expected directories to start in config order, got:\n%s", output)
+	}
+}
+
+func TestCommandOptions_CommandOverridesDirectory(t *testing.T) {
+	runner := NewMockRunner()
+
+	pkg := config.PackageConfig{Run: config.Directories{
+		{Dir: "/opt/test", Timeout: time.Minute, IdleTimeout: 30 * time.Second, Commands: []config.Command{
+			{Cmd: "pull", Timeout: 5 * time.Minute, Children: []config.Command{{Cmd: "up"}}},
+		}},
+	}}
+
+	Execute(runner, event.Event{Package: event.Package{Name: "test-pkg"}, DeliveryID: "delivery-17"}, pkg)
+
+	if len(runner.Opts) != 2 {
+		t.Fatalf("expected 2 commands, got %d", len(runner.Opts))
+	}
+	if got := runner.Opts[0]; got.Timeout != 5*time.Minute || got.IdleTimeout != 30*time.Second {
+		t.Errorf("expected parent timeouts 5m/30s, got %s/%s", got.Timeout, got.IdleTimeout)
+	}
+	if got := runner.Opts[1]; got.Timeout != time.Minute || got.IdleTimeout != 30*time.Second {
+		t.Errorf("expected child timeouts 1m/30s, got %s/%s", got.Timeout, got.IdleTimeout)
+	}
+}
+
+func TestLogOutput_TimedOutCommand(t *testing.T) {
+	runner := NewMockRunner()
+	runner.SetResult("hang", "", &TimeoutError{Idle: true, After: 30 * time.Second})
+	runner.SetResult("bad", "", fmt.Errorf("exit status 1"))
+
+	commands := dirCommands("/opt/test", []config.Command{
+		{Cmd: "hang", Children: []config.Command{{Cmd: "child"}}},
+	})
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-321"}, commands)
+	})
+
+	if !strings.Contains(output, "command 1 of 1 timed out: idle timeout: no output for 30s") {
+		t.Errorf("expected timeout log, got:\n%s", output)
+	}
+	if strings.Contains(output, "command 1 of 1 failed") {
+		t.Errorf("expected timeout to be logged distinctly from failure, got:\n%s", output)
+	}
+	assertRanUnordered(t, runner, "hang")
+}
+
+// flakyRunner fails the first failures calls of each command, then succeeds.
+type flakyRunner struct {
+	MockRunner
+	failures int
+	calls    map[string]int
+}
+
+func (f *flakyRunner) Run(cmd string, dir string, opts Options) (string, error) {
+	f.MockRunner.Run(cmd, dir, opts)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[cmd]++
+	if f.calls[cmd] <= f.failures {
+		return fmt.Sprintf("attempt %d output", f.calls[cmd]), fmt.Errorf("exit status 1")
+	}
+	return "", nil
+}
+
+func TestRetry_SucceedsAfterFailures(t *testing.T) {
+	runner := &flakyRunner{failures: 2, calls: map[string]int{}}
+
+	commands := dirCommands("/opt/test", []config.Command{
+		{Cmd: "docker pull", Retries: 3, Backoff: time.Millisecond, Children: []config.Command{
+			{Cmd: "docker compose up -d"},
+		}},
+	})
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-500"}, commands)
+	})
+
+	expected := []string{"docker pull", "docker pull", "docker pull", "docker compose up -d"}
+	if !slices.Equal(runner.Commands, expected) {
+		t.Fatalf("expected commands %v, got %v", expected, runner.Commands)
+	}
+
+	for _, exp := range []string{
+		"output: attempt 1 output",
+		"command 1 of 1 (attempt 1 of 4) failed: exit status 1",
+		"output: attempt 2 output",
+		"command 1 of 1 (attempt 2 of 4) failed: exit status 1",
+		"retrying docker pull in 1ms",
+		"retrying docker pull in 2ms",
+		"command 1 of 1 (attempt 3 of 4) succeeded",
+	} {
+		if !strings.Contains(output, exp) {
+			t.Errorf("expected log to contain %q, got:\n%s", exp, output)
+		}
+	}
+}
+
+func TestExecute_LogsCommandAsWritten(t *testing.T) {
+	runner := &flakyRunner{failures: 1, calls: map[string]int{}}
+
+	commands := dirCommands("/opt/test", []config.Command{{
+		Cmd:     "curl -H X-Token=s3cret {{.Tag}}",
+		Raw:     "curl -H X-Token=${file:/run/token} {{.Tag}}",
+		Retries: 1,
+		Backoff: time.Millisecond,
+	}})
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, Tag: "v1", DeliveryID: "d-502"}, commands)
+	})
+
+	if expected := []string{"curl -H X-Token=s3cret v1", "curl -H X-Token=s3cret v1"}; !slices.Equal(runner.Commands, expected) {
+		t.Fatalf("expected the expanded command to run, got %v", runner.Commands)
+	}
+	for _, exp := range []string{
+		"running command 1 of 1 (attempt 1 of 2): curl -H X-Token=${file:/run/token} v1",
+		"retrying curl -H X-Token=${file:/run/token} v1 in 1ms",
+	} {
+		if !strings.Contains(output, exp) {
+			t.Errorf("expected log to contain %q, got:\n%s", exp, output)
+		}
+	}
+	if strings.Contains(output, "s3cret") {
+		t.Errorf("expected the expanded value to stay out of the log, got:\n%s", output)
+	}
+}
+
+func TestRetry_ExhaustedSkipsChildren(t *testing.T) {
+	runner := &flakyRunner{failures: 10, calls: map[string]int{}}
+
+	commands := dirCommands("/opt/test", []config.Command{
+		{Cmd: "docker pull", Retries: 2, Backoff: time.Millisecond, Children: []config.Command{
+			{Cmd: "docker compose up -d"},
+		}},
+	})
+
+	Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-501"}, commands)
+
+	expected := []string{"docker pull", "docker pull", "docker pull"}
+	if !slices.Equal(runner.Commands, expected) {
+		t.Fatalf("expected commands %v, got %v", expected, runner.Commands)
+	}
+}
+
+func TestRetryDelay_Doubles(t *testing.T) {
+	base := 5 * time.Second
+	expected := []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second}
+	for i, want := range expected {
+		if got := retryDelay(base, i+1); got != want {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, want, got)
+		}
+	}
+
+	if got := retryDelay(0, 1); got != defaultBackoff {
+		t.Errorf("expected default backoff %s, got %s", defaultBackoff, got)
+	}
+}
+
+func TestRetryDelay_Capped(t *testing.T) {
+	for _, attempt := range []int{8, 20, 64, 100} {
+		if got := retryDelay(5*time.Second, attempt); got != maxBackoff {
+			t.Errorf("attempt %d: expected the delay capped at %s, got %s", attempt, maxBackoff, got)
+		}
+	}
+	if got := retryDelay(10*time.Minute, 3); got != 10*time.Minute {
+		t.Errorf("expected a base beyond the cap to be used as is, got %s", got)
+	}
+}
+
 func TestShellRunner_Integration(t *testing.T) {
 	runner := ShellRunner{}
 
-	output, err := runner.Run("echo hello world", "")
+	output, err := runner.Run("echo hello world", "", Options{})
 	if err != nil {
 		t.Fatalf("expected no error, got: %v", err)
 	}
@@ -290,7 +692,7 @@ func TestShellRunner_Integration(t *testing.T) {
 func TestShellRunner_Integration_FailingCommand(t *testing.T) {
 	runner := ShellRunner{}
 
-	_, err := runner.Run("false", "")
+	_, err := runner.Run("false", "", Options{})
 	if err == nil {
 		t.Fatal("expected error for failing command, got nil")
 	}
@@ -308,7 +710,7 @@ func TestShellRunner_Integration_WithDirectory(t *testing.T) {
 	runner := ShellRunner{}
 
 	// Run cat in the temp directory - use relative path to verify dir works
-	output, err := runner.Run("cat hello.txt", tmpDir)
+	output, err := runner.Run("cat hello.txt", tmpDir, Options{})
 	if err != nil {
 		t.Fatalf("expected no error, got: %v", err)
 	}
@@ -328,7 +730,7 @@ func TestShellRunner_Integration_WithExecute(t *testing.T) {
 	})
 
 	output := captureLog(func() {
-		Execute(runner, "integration-pkg", "int-001", commands)
+		Execute(runner, event.Event{Package: event.Package{Name: "integration-pkg"}, DeliveryID: "int-001"}, commands)
 	})
 
 	if !strings.Contains(output, "step1") {
@@ -341,3 +743,472 @@ func TestShellRunner_Integration_WithExecute(t *testing.T) {
 		t.Errorf("expected success log, got:\n%s", output)
 	}
 }
+
+func TestExecute_ExposesEvent(t *testing.T) {
+	runner := NewMockRunner()
+	pkg := dirCommands("/opt/test", []config.Command{{Cmd: "deploy"}})
+	pkg.PayloadStdin = true
+
+	ev := event.Event{
+		Package:    event.Package{Name: "mypkg"},
+		Version:    event.Version{ID: 42, SHA: "sha256:abc"},
+		Tag:        "latest",
+		DeliveryID: "d-600",
+		Repository: "owner/repo",
+		Sender:     "octocat",
+		Payload:    []byte(`{"action":"published"}`),
+	}
+	Execute(runner, ev, pkg)
+
+	if len(runner.Opts) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(runner.Opts))
+	}
+	opts := runner.Opts[0]
+	for _, want := range []string{
+		"STEAKPIE_PACKAGE=mypkg",
+		"STEAKPIE_TAG=latest",
+		"STEAKPIE_SHA=sha256:abc",
+		"STEAKPIE_VERSION_ID=42",
+		"STEAKPIE_DELIVERY_ID=d-600",
+		"STEAKPIE_REPOSITORY=owner/repo",
+		"STEAKPIE_SENDER=octocat",
+	} {
+		if !slices.Contains(opts.Env, want) {
+			t.Errorf("expected env to contain %s, got %v", want, opts.Env)
+		}
+	}
+	if string(opts.Stdin) != `{"action":"published"}` {
+		t.Errorf("expected payload on stdin, got %q", opts.Stdin)
+	}
+
+	var payloadFile string
+	for _, kv := range opts.Env {
+		if v, ok := strings.CutPrefix(kv, "STEAKPIE_PAYLOAD_FILE="); ok {
+			payloadFile = v
+		}
+	}
+	if payloadFile == "" {
+		t.Fatal("expected STEAKPIE_PAYLOAD_FILE to be set")
+	}
+	if _, err := os.Stat(payloadFile); !os.IsNotExist(err) {
+		t.Errorf("expected payload file to be removed after execution, got: %v", err)
+	}
+}
+
+func TestShellRunner_Integration_Payload(t *testing.T) {
+	runner := ShellRunner{}
+	pkg := dirCommands("", []config.Command{
+		{Cmd: `echo "tag=$STEAKPIE_TAG file=$(cat "$STEAKPIE_PAYLOAD_FILE") stdin=$(cat)"`},
+	})
+	pkg.PayloadStdin = true
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "integration-pkg"}, Tag: "v1", DeliveryID: "int-002", Payload: []byte(`{"a":1}`)}, pkg)
+	})
+
+	if !strings.Contains(output, `tag=v1 file={"a":1} stdin={"a":1}`) {
+		t.Errorf("expected command to see the event, got:\n%s", output)
+	}
+}
+
+func TestExecute_RendersCommandTemplates(t *testing.T) {
+	runner := NewMockRunner()
+	pkg := dirCommands("/opt/test", []config.Command{
+		{Cmd: `docker pull app:{{ .Tag | trimPrefix "v" }}`},
+		{Cmd: "echo {{ .Missing }}"},
+	})
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, Tag: "v2.1.0", DeliveryID: "d-601"}, pkg)
+	})
+
+	if len(runner.Commands) != 1 || runner.Commands[0] != "docker pull app:2.1.0" {
+		t.Errorf("expected only the rendered command to run, got %v", runner.Commands)
+	}
+	if !strings.Contains(output, `failed: cannot render "echo {{ .Missing }}"`) {
+		t.Errorf("expected render failure to be logged, got:\n%s", output)
+	}
+}
+
+func TestExecute_Shells(t *testing.T) {
+	tests := []struct {
+		name      string
+		shell     config.Shell
+		cmd       config.Command
+		wantShell []string
+		wantArgv  []string
+	}{
+		{name: "default", cmd: config.Command{Cmd: "echo hi"}},
+		{name: "custom shell", shell: config.Shell{"sh", "-lc"}, cmd: config.Command{Cmd: "echo hi"}, wantShell: []string{"sh", "-lc"}},
+		{name: "no shell", shell: config.Shell{"none"}, cmd: config.Command{Cmd: `echo 'a b' {{.Tag}}`}, wantArgv: []string{"echo", "a b", "v1"}},
+		{name: "argv", shell: config.Shell{"sh", "-lc"}, cmd: config.Command{Cmd: "echo {{.Tag}}", Argv: []string{"echo", "{{.Tag}}"}}, wantArgv: []string{"echo", "v1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := NewMockRunner()
+			pkg := dirCommands("/opt/test", []config.Command{tt.cmd})
+			pkg.Shell = tt.shell
+
+			Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, Tag: "v1", DeliveryID: "d-700"}, pkg)
+
+			if len(runner.Opts) != 1 {
+				t.Fatalf("expected 1 command, got %d", len(runner.Opts))
+			}
+			opts := runner.Opts[0]
+			if !slices.Equal(opts.Shell, tt.wantShell) {
+				t.Errorf("expected shell %q, got %q", tt.wantShell, opts.Shell)
+			}
+			if !slices.Equal(opts.Argv, tt.wantArgv) {
+				t.Errorf("expected argv %q, got %q", tt.wantArgv, opts.Argv)
+			}
+		})
+	}
+}
+
+func TestExecute_NoShellUnterminatedQuote(t *testing.T) {
+	runner := NewMockRunner()
+	pkg := dirCommands("/opt/test", []config.Command{{Cmd: `echo "oops`}})
+	pkg.Shell = config.Shell{"none"}
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-701"}, pkg)
+	})
+
+	if len(runner.Commands) != 0 {
+		t.Errorf("expected nothing to run, got %v", runner.Commands)
+	}
+	if !strings.Contains(output, "unterminated \" quote") {
+		t.Errorf("expected split failure to be logged, got:\n%s", output)
+	}
+}
+
+func TestExecute_RunAs(t *testing.T) {
+	runner := NewMockRunner()
+	pkg := config.PackageConfig{Run: config.Directories{
+		{Dir: "/srv/app", RunAs: &config.RunAs{Name: "deploy", Home: "/home/deploy", Uid: 1001, Gid: 1002, Groups: []uint32{999}}, Commands: []config.Command{{Cmd: "whoami"}}},
+		{Dir: "/srv/other", Commands: []config.Command{{Cmd: "whoami"}}},
+	}}
+
+	Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-900"}, pkg)
+
+	if len(runner.Opts) != 2 {
+		t.Fatalf("expected 2 commands, got %d", len(runner.Opts))
+	}
+	for i, dir := range runner.Dirs {
+		opts := runner.Opts[i]
+		switch dir {
+		case "/srv/app":
+			cred := opts.Credential
+			if cred == nil || cred.Uid != 1001 || cred.Gid != 1002 || !slices.Equal(cred.Groups, []uint32{999}) {
+				t.Errorf("expected credential for deploy, got %+v", cred)
+			}
+			for _, want := range []string{"HOME=/home/deploy", "USER=deploy", "LOGNAME=deploy"} {
+				if !slices.Contains(opts.Env, want) {
+					t.Errorf("expected env to contain %s", want)
+				}
+			}
+		case "/srv/other":
+			if opts.Credential != nil {
+				t.Errorf("expected no credential, got %+v", opts.Credential)
+			}
+		}
+	}
+}
+
+func TestExecute_FailureAndAlwaysBranches(t *testing.T) {
+	tests := []struct {
+		name     string
+		fail     []string
+		expected []string
+		wantOK   bool
+	}{
+		{
+			name:     "success runs children and always",
+			expected: []string{"deploy", "smoke", "logs"},
+			wantOK:   true,
+		},
+		{
+			name:     "failure runs on_failure and always",
+			fail:     []string{"deploy"},
+			expected: []string{"deploy", "rollback", "logs"},
+		},
+		{
+			name:     "failing always fails the command",
+			fail:     []string{"logs"},
+			expected: []string{"deploy", "smoke", "logs"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := NewMockRunner()
+			for _, cmd := range tt.fail {
+				runner.SetResult(cmd, "", fmt.Errorf("exit status 1"))
+			}
+			pkg := config.PackageConfig{
+				Setup: config.Directories{{Dir: "/opt/test", Commands: []config.Command{{
+					Cmd:       "deploy",
+					Children:  []config.Command{{Cmd: "smoke"}},
+					OnFailure: []config.Command{{Cmd: "rollback"}},
+					Always:    []config.Command{{Cmd: "logs"}},
+				}}}},
+				Run: config.Directories{{Dir: "/opt/test", Commands: []config.Command{{Cmd: "after-setup"}}}},
+			}
+
+			Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-1000"}, pkg)
+
+			expected := tt.expected
+			if tt.wantOK {
+				expected = append(slices.Clone(expected), "after-setup")
+			}
+			if !slices.Equal(runner.Commands, expected) {
+				t.Errorf("expected commands %v, got %v", expected, runner.Commands)
+			}
+		})
+	}
+}
+
+func TestLogOutput_OnFailureBranch(t *testing.T) {
+	runner := NewMockRunner()
+	runner.SetResult("deploy", "", fmt.Errorf("exit status 1"))
+	pkg := dirCommands("/opt/test", []config.Command{{
+		Cmd:       "deploy",
+		OnFailure: []config.Command{{Cmd: "rollback"}},
+		Always:    []config.Command{{Cmd: "logs"}},
+	}})
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-1001"}, pkg)
+	})
+
+	for _, want := range []string{
+		"[/opt/test] command 1 of 1 failed, running on_failure commands",
+		"[/opt/test] running command 1 of 1: rollback",
+		"[/opt/test] command 1 of 1 finished, running always commands",
+		"[/opt/test] running command 1 of 1: logs",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected log to contain %q, got:\n%s", want, output)
+		}
+	}
+}
+
+// exitStatus is an error carrying an exit code, like *exec.ExitError.
+type exitStatus int
+
+func (e exitStatus) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e exitStatus) ExitCode() int { return int(e) }
+
+func TestOutcome(t *testing.T) {
+	tests := []struct {
+		name   string
+		cmd    config.Command
+		output string
+		err    error
+		wantOK bool
+		want   string
+	}{
+		{name: "exit 0", wantOK: true, want: "succeeded"},
+		{name: "exit 1", err: exitStatus(1), want: "failed: exit status 1"},
+		{name: "allowed exit code", cmd: config.Command{SuccessExitCodes: []int{0, 1}}, err: exitStatus(1), wantOK: true, want: "succeeded: exit status 1 is allowed"},
+		{name: "exit 0 not allowed", cmd: config.Command{SuccessExitCodes: []int{3}}, want: "failed: exit status 0 is not in success_exit_codes"},
+		{name: "other exit code", cmd: config.Command{SuccessExitCodes: []int{0, 1}}, err: exitStatus(2), want: "failed: exit status 2"},
+		{name: "not started", cmd: config.Command{SuccessExitCodes: []int{0, 1}}, err: fmt.Errorf("exec: not found"), want: "failed: exec: not found"},
+		{
+			name:   "fail if output matches",
+			cmd:    config.Command{FailIfOutputMatches: regexp.MustCompile(`(?i)error`)},
+			output: "migrations: ERROR connecting",
+			want:   "failed: output matches (?i)error",
+		},
+		{
+			name:   "succeed if output matches",
+			cmd:    config.Command{SucceedIfOutputMatches: regexp.MustCompile(`nothing to do`)},
+			output: "nothing to do",
+			err:    exitStatus(1),
+			wantOK: true,
+			want:   "succeeded: output matches nothing to do",
+		},
+		{
+			name:   "succeed if output doesn't match",
+			cmd:    config.Command{SucceedIfOutputMatches: regexp.MustCompile(`nothing to do`)},
+			output: "applied 3 migrations",
+			err:    exitStatus(1),
+			want:   "failed: exit status 1",
+		},
+		{
+			name:   "fail wins over succeed",
+			cmd:    config.Command{FailIfOutputMatches: regexp.MustCompile(`bad`), SucceedIfOutputMatches: regexp.MustCompile(`ok`)},
+			output: "ok but bad",
+			want:   "failed: output matches bad",
+		},
+		{
+			name:   "timeout always fails",
+			cmd:    config.Command{SucceedIfOutputMatches: regexp.MustCompile(`.`)},
+			output: "partial",
+			err:    &TimeoutError{After: time.Second},
+			want:   "timed out: timeout: still running after 1s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, got := outcome(tt.cmd, tt.output, tt.err)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("outcome() = %v, %q, want %v, %q", ok, got, tt.wantOK, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecute_AllowedExitCodeRunsChildren(t *testing.T) {
+	runner := NewMockRunner()
+	runner.SetResult("./migrate", "no pending migrations", exitStatus(1))
+	pkg := dirCommands("/opt/test", []config.Command{{
+		Cmd:              "./migrate",
+		SuccessExitCodes: []int{0, 1},
+		Children:         []config.Command{{Cmd: "docker compose up -d"}},
+	}})
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-1100"}, pkg)
+	})
+
+	if !slices.Equal(runner.Commands, []string{"./migrate", "docker compose up -d"}) {
+		t.Errorf("expected children to run after an allowed exit code, got %v", runner.Commands)
+	}
+	if !strings.Contains(output, "[/opt/test] command 1 of 1 succeeded: exit status 1 is allowed") {
+		t.Errorf("expected allowed exit code to be logged, got:\n%s", output)
+	}
+}
+
+func TestShellRunner_Integration_ExitCodes(t *testing.T) {
+	runner := ShellRunner{}
+	pkg := dirCommands("", []config.Command{{
+		Cmd:              "exit 3",
+		SuccessExitCodes: []int{3},
+		Children:         []config.Command{{Cmd: "echo child-ran"}},
+	}})
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "integration-pkg"}, DeliveryID: "int-003"}, pkg)
+	})
+
+	if !strings.Contains(output, "exit status 3 is allowed") || !strings.Contains(output, "child-ran") {
+		t.Errorf("expected exit status 3 to count as success, got:\n%s", output)
+	}
+}
+
+func TestExecute_CaptureFlowsToLaterCommands(t *testing.T) {
+	runner := NewMockRunner()
+	runner.SetResult("resolve", "  sha256:old\n", nil)
+	runner.SetResult("deploy", "", fmt.Errorf("exit status 1"))
+	pkg := dirCommands("/opt/test", []config.Command{
+		{Cmd: "resolve", Capture: "IMAGE", Children: []config.Command{{Cmd: "child {{.Outputs.IMAGE}}"}}},
+		{Cmd: "deploy", OnFailure: []config.Command{{Cmd: "rollback {{.Outputs.IMAGE}}"}}},
+	})
+
+	Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-1200"}, pkg)
+
+	if runner.Commands[0] != "resolve" {
+		t.Errorf("expected later siblings to wait for the capturing command, got %v", runner.Commands)
+	}
+	assertRanUnordered(t, runner, "resolve", "child sha256:old", "deploy", "rollback sha256:old")
+
+	for i, cmd := range runner.Commands {
+		hasVar := slices.Contains(runner.Opts[i].Env, "STEAKPIE_OUT_IMAGE=sha256:old")
+		if cmd == "resolve" && hasVar {
+			t.Error("expected the capturing command not to see its own output")
+		}
+		if cmd != "resolve" && !hasVar {
+			t.Errorf("expected %q to see STEAKPIE_OUT_IMAGE, got %v", cmd, runner.Opts[i].Env)
+		}
+	}
+}
+
+func TestExecute_FailedCaptureIsNotSet(t *testing.T) {
+	runner := NewMockRunner()
+	runner.SetResult("resolve", "partial", fmt.Errorf("exit status 1"))
+	pkg := dirCommands("/opt/test", []config.Command{
+		{Cmd: "resolve", Capture: "IMAGE"},
+		{Cmd: "echo $STEAKPIE_OUT_IMAGE"},
+		{Cmd: "echo {{.Outputs.IMAGE}}"},
+	})
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-1201"}, pkg)
+	})
+
+	if !slices.Equal(runner.Commands, []string{"resolve", "echo $STEAKPIE_OUT_IMAGE"}) {
+		t.Errorf("expected the template using the missing output not to run, got %v", runner.Commands)
+	}
+	for _, kv := range runner.Opts[1].Env {
+		if strings.HasPrefix(kv, "STEAKPIE_OUT_IMAGE=") {
+			t.Errorf("expected no output variable after a failed capture, got %s", kv)
+		}
+	}
+	if !strings.Contains(output, "command 3 of 3 failed: cannot render") {
+		t.Errorf("expected render failure to be logged, got:\n%s", output)
+	}
+}
+
+func TestShellRunner_Integration_Capture(t *testing.T) {
+	runner := ShellRunner{}
+	pkg := dirCommands("", []config.Command{
+		{Cmd: "echo noise >&2; echo '  captured value  '", Capture: "VALUE"},
+		{Cmd: `echo "env=[$STEAKPIE_OUT_VALUE] tmpl=[{{.Outputs.VALUE}}]"`},
+	})
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "integration-pkg"}, DeliveryID: "int-004"}, pkg)
+	})
+
+	if !strings.Contains(output, "env=[captured value] tmpl=[captured value]") {
+		t.Errorf("expected trimmed stdout without stderr to be passed on, got:\n%s", output)
+	}
+}
+
+func TestExecute_StepsDiamond(t *testing.T) {
+	runner := NewMockRunner()
+	runner.SetResult("pull", "sha256:new\n", nil)
+	pkg := dirCommands("/opt/test", []config.Command{
+		{Cmd: "restart web", Needs: []string{"migrate", "warm-cache"}},
+		{Cmd: "migrate {{.Outputs.IMAGE}}", Name: "migrate", Needs: []string{"pull"}},
+		{Cmd: "warm-cache", Name: "warm-cache", Needs: []string{"pull"}},
+		{Cmd: "pull", Name: "pull", Capture: "IMAGE"},
+	})
+
+	Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-1300"}, pkg)
+
+	assertRanUnordered(t, runner, "pull", "migrate sha256:new", "warm-cache", "restart web")
+	order := func(cmd string) int { return slices.Index(runner.Commands, cmd) }
+	if order("pull") != 0 || order("restart web") != 3 {
+		t.Errorf("expected pull first and the restart last, got %v", runner.Commands)
+	}
+	if i := order("restart web"); !slices.Contains(runner.Opts[i].Env, "STEAKPIE_OUT_IMAGE=sha256:new") {
+		t.Errorf("expected the restart to see outputs captured by the steps before it, got %v", runner.Opts[i].Env)
+	}
+}
+
+func TestExecute_StepSkippedWhenNeedFails(t *testing.T) {
+	runner := NewMockRunner()
+	runner.SetResult("warm-cache", "", fmt.Errorf("exit status 1"))
+	pkg := dirCommands("/opt/test", []config.Command{
+		{Cmd: "migrate", Name: "migrate", Children: []config.Command{{Cmd: "check"}}},
+		{Cmd: "warm-cache", Name: "warm-cache"},
+		{Cmd: "restart web", Needs: []string{"migrate", "warm-cache"}},
+		{Cmd: "notify", Needs: []string{"migrate"}},
+	})
+
+	output := captureLog(func() {
+		Execute(runner, event.Event{Package: event.Package{Name: "mypkg"}, DeliveryID: "d-1301"}, pkg)
+	})
+
+	assertRanUnordered(t, runner, "migrate", "check", "warm-cache", "notify")
+	if slices.Index(runner.Commands, "notify") < slices.Index(runner.Commands, "check") {
+		t.Errorf("expected a step to wait for the children of the steps it needs, got %v", runner.Commands)
+	}
+	if !strings.Contains(output, "skipping command 3 of 4 because warm-cache did not succeed") {
+		t.Errorf("expected the skipped step to be logged, got:\n%s", output)
+	}
+}
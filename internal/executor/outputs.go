@@ -0,0 +1,29 @@
+package executor
+
+import "maps"
+
+// outputVarPrefix starts the name of the variable holding a captured output.
+const outputVarPrefix = "STEAKPIE_OUT_"
+
+// outputs holds captured command outputs by name. It is never modified once
+// shared; with returns a copy instead.
+type outputs map[string]string
+
+// with returns a copy of o that also holds name.
+func (o outputs) with(name, value string) outputs {
+	next := make(outputs, len(o)+1)
+	maps.Copy(next, o)
+	next[name] = value
+	return next
+}
+
+// merge returns a copy of o that also holds everything in other.
+func (o outputs) merge(other outputs) outputs {
+	if len(other) == 0 {
+		return o
+	}
+	next := make(outputs, len(o)+len(other))
+	maps.Copy(next, o)
+	maps.Copy(next, other)
+	return next
+}
@@ -0,0 +1,176 @@
+package executor
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jc/steakpie/internal/config"
+	"github.com/jc/steakpie/internal/event"
+)
+
+// Plan writes the commands Execute would run for the event, without running
+// anything: each phase's directories in order, and under them the commands
+// rendered against the event, nested the way they run, with the settings
+// that decide when they run and whether they succeed. Captured outputs aren't
+// known ahead of time, so templates using them show the variable that will
+// hold the output instead.
+func Plan(w io.Writer, ev event.Event, pkg config.PackageConfig) {
+	ev.Outputs = make(map[string]string)
+	for _, dirs := range []config.Directories{pkg.Setup, pkg.Run, pkg.Teardown} {
+		for _, d := range dirs {
+			collectCaptures(d.Commands, ev.Outputs)
+		}
+	}
+	ex := &execution{event: ev, shell: pkg.Shell}
+
+	p := planner{w: w, ex: ex}
+	p.phase("setup", pkg.Setup, "")
+	runWhen := ""
+	if len(pkg.Setup) > 0 {
+		runWhen = "only if setup succeeds"
+	}
+	p.phase("run", pkg.Run, runWhen)
+	p.phase("teardown", pkg.Teardown, "always")
+}
+
+// collectCaptures adds a placeholder for every output captured in cmds to outputs.
+func collectCaptures(cmds []config.Command, outputs map[string]string) {
+	for _, cmd := range cmds {
+		if cmd.Capture != "" {
+			outputs[cmd.Capture] = "$" + outputVarPrefix + cmd.Capture
+		}
+		collectCaptures(cmd.Children, outputs)
+		collectCaptures(cmd.OnFailure, outputs)
+		collectCaptures(cmd.Always, outputs)
+	}
+}
+
+// planner writes a plan as an indented tree.
+type planner struct {
+	w  io.Writer
+	ex *execution
+}
+
+func (p planner) line(depth int, format string, args ...any) {
+	fmt.Fprintf(p.w, "%s%s\n", strings.Repeat("  ", depth), fmt.Sprintf(format, args...))
+}
+
+func (p planner) phase(name string, dirs config.Directories, when string) {
+	if len(dirs) == 0 {
+		return
+	}
+	if when != "" {
+		name += " (" + when + ")"
+	}
+	p.line(0, "%s:", name)
+	for _, d := range dirs {
+		p.line(1, "%s%s", dirLabel(d), settings(dirSettings(d)))
+		p.level(2, d.Commands)
+	}
+}
+
+func (p planner) level(depth int, cmds []config.Command) {
+	for i, cmd := range cmds {
+		text := p.render(cmd)
+		s := commandSettings(cmd)
+		if deps := config.StepDeps(cmds, i); len(deps) > 0 {
+			after := make([]string, len(deps))
+			for j, dep := range deps {
+				after[j] = strconv.Itoa(dep + 1)
+			}
+			s = append([]string{"after " + strings.Join(after, ", ")}, s...)
+		}
+		p.line(depth, "%d. %s%s", i+1, text, settings(s))
+		p.branch(depth+1, "on success", cmd.Children)
+		p.branch(depth+1, "on failure", cmd.OnFailure)
+		p.branch(depth+1, "always", cmd.Always)
+	}
+}
+
+func (p planner) branch(depth int, label string, cmds []config.Command) {
+	if len(cmds) == 0 {
+		return
+	}
+	p.line(depth, "%s:", label)
+	p.level(depth+1, cmds)
+}
+
+// render returns the command as it would run, or why it can't. A command
+// that ${...} expanded shows as written, so the plan gives no secrets away.
+func (p planner) render(cmd config.Command) string {
+	prepared, err := p.ex.prepare(cmd, p.ex.event.Outputs)
+	if err != nil {
+		return fmt.Sprintf("%s (will fail: %v)", cmd.Shown(), err)
+	}
+	if prepared.Raw != "" {
+		return prepared.Raw
+	}
+	if prepared.Argv != nil {
+		return fmt.Sprintf("%q", prepared.Argv)
+	}
+	return prepared.Cmd
+}
+
+// dirLabel names a directory, which is steakpie's working directory when empty.
+func dirLabel(d config.Directory) string {
+	if d.Dir == "" {
+		return "(current directory)"
+	}
+	return d.Dir
+}
+
+func dirSettings(d config.Directory) []string {
+	var s []string
+	if len(d.Needs) > 0 {
+		s = append(s, "needs "+strings.Join(d.Needs, ", "))
+	}
+	if d.RunAs != nil {
+		s = append(s, "as user "+d.RunAs.Name)
+	}
+	if d.Timeout > 0 {
+		s = append(s, fmt.Sprintf("timeout %s", d.Timeout))
+	}
+	if d.IdleTimeout > 0 {
+		s = append(s, fmt.Sprintf("idle timeout %s", d.IdleTimeout))
+	}
+	return s
+}
+
+func commandSettings(cmd config.Command) []string {
+	var s []string
+	if cmd.Name != "" {
+		s = append(s, "step "+cmd.Name)
+	}
+	if cmd.Capture != "" {
+		s = append(s, "captures "+cmd.Capture)
+	}
+	if cmd.Timeout > 0 {
+		s = append(s, fmt.Sprintf("timeout %s", cmd.Timeout))
+	}
+	if cmd.IdleTimeout > 0 {
+		s = append(s, fmt.Sprintf("idle timeout %s", cmd.IdleTimeout))
+	}
+	if cmd.Retries > 0 {
+		s = append(s, fmt.Sprintf("retries %d, backoff %s", cmd.Retries, retryDelay(cmd.Backoff, 1)))
+	}
+	if len(cmd.SuccessExitCodes) > 0 {
+		s = append(s, fmt.Sprintf("succeeds on exit %v", cmd.SuccessExitCodes))
+	}
+	if cmd.FailIfOutputMatches != nil {
+		s = append(s, "fails if output matches "+cmd.FailIfOutputMatches.String())
+	}
+	if cmd.SucceedIfOutputMatches != nil {
+		s = append(s, "succeeds if output matches "+cmd.SucceedIfOutputMatches.String())
+	}
+	return s
+}
+
+// settings formats a list of settings to follow a directory or command.
+func settings(s []string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return "  [" + strings.Join(s, "; ") + "]"
+}
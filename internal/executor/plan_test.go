@@ -0,0 +1,88 @@
+package executor
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jc/steakpie/internal/config"
+	"github.com/jc/steakpie/internal/event"
+)
+
+func TestPlan(t *testing.T) {
+	pkg := config.PackageConfig{
+		Setup: config.Directories{{Dir: "/srv/db", Commands: []config.Command{{Cmd: "docker compose pull"}}}},
+		Run: config.Directories{{
+			Dir:     "/srv/app",
+			Needs:   []string{"/srv/db"},
+			Timeout: 5 * time.Minute,
+			Commands: []config.Command{
+				{Cmd: "docker inspect {{.Package.Name}}", Name: "inspect", Capture: "OLD"},
+				{
+					Argv:                []string{"docker", "tag", "app:{{.Tag}}", "app:live"},
+					Needs:               []string{"inspect"},
+					Retries:             2,
+					SuccessExitCodes:    []int{0, 3},
+					FailIfOutputMatches: regexp.MustCompile("ERROR"),
+					Children:            []config.Command{{Cmd: "echo {{.Outputs.OLD}}"}},
+					OnFailure:           []config.Command{{Cmd: "echo {{.Nope}}"}},
+				},
+			},
+		}},
+	}
+	ev := event.Event{Package: event.Package{Name: "app"}, Tag: "v1.2.0"}
+
+	var out strings.Builder
+	Plan(&out, ev, pkg)
+
+	expected := `setup:
+  /srv/db
+    1. docker compose pull
+run (only if setup succeeds):
+  /srv/app  [needs /srv/db; timeout 5m0s]
+    1. docker inspect app  [step inspect; captures OLD]
+    2. ["docker" "tag" "app:v1.2.0" "app:live"]  [after 1; retries 2, backoff 2s; succeeds on exit [0 3]; fails if output matches ERROR]
+      on success:
+        1. echo $STEAKPIE_OUT_OLD
+      on failure:
+        1. echo {{.Nope}} (will fail: cannot render "echo {{.Nope}}": template: command:1:7: executing "command" at <.Nope>: can't evaluate field Nope in type event.Event)
+`
+	if out.String() != expected {
+		t.Errorf("expected plan:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestPlan_ShowsCommandAsWritten(t *testing.T) {
+	pkg := dirCommands("/srv/app", []config.Command{
+		{Cmd: "curl -H X-Token=s3cret", Raw: "curl -H X-Token=${file:/run/token}"},
+		{Cmd: "deploy s3cret", Raw: "deploy ${TOKEN}", Argv: []string{"deploy", "s3cret"}},
+	})
+
+	var out strings.Builder
+	Plan(&out, event.Event{}, pkg)
+
+	if strings.Contains(out.String(), "s3cret") {
+		t.Errorf("expected the expanded value to stay out of the plan, got:\n%s", out.String())
+	}
+	for _, want := range []string{"1. curl -H X-Token=${file:/run/token}", "2. deploy ${TOKEN}"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected plan to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestPlan_RunsNothing(t *testing.T) {
+	runner := NewMockRunner()
+	pkg := dirCommands("/opt/test", []config.Command{{Cmd: "echo hello"}})
+
+	var out strings.Builder
+	Plan(&out, event.Event{Package: event.Package{Name: "mypkg"}}, pkg)
+
+	if len(runner.Commands) != 0 {
+		t.Errorf("expected nothing to run, got %v", runner.Commands)
+	}
+	if !strings.Contains(out.String(), "1. echo hello") {
+		t.Errorf("expected the command in the plan, got:\n%s", out.String())
+	}
+}
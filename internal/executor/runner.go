@@ -0,0 +1,170 @@
+package executor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Runner executes a command in a given directory, returns combined stdout+stderr and error.
+type Runner interface {
+	Run(cmd string, dir string, opts Options) (output string, err error)
+}
+
+// Options holds the per-command settings a Runner must honour.
+// A zero Timeout or IdleTimeout means no limit.
+type Options struct {
+	Timeout     time.Duration       // total run time
+	IdleTimeout time.Duration       // time without any output
+	Env         []string            // the complete environment, in KEY=value form; nil means steakpie's own
+	Stdin       []byte              // standard input; nil means none
+	Shell       []string            // interpreter and arguments to run cmd with; nil means bash -lc
+	Argv        []string            // program and arguments to run directly instead of cmd
+	Credential  *syscall.Credential // user and groups to run as; nil means steakpie's own
+	Stdout      io.Writer           // also receives standard output, if set
+	Shown       string              // cmd as logs may show it, if cmd holds values they mustn't
+}
+
+// TimeoutError reports that a command was stopped because it ran too long
+// or went quiet for too long.
+type TimeoutError struct {
+	Idle  bool
+	After time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Idle {
+		return fmt.Sprintf("idle timeout: no output for %s", e.After)
+	}
+	return fmt.Sprintf("timeout: still running after %s", e.After)
+}
+
+// defaultKillGrace is how long a timed out command gets to exit after SIGTERM before SIGKILL.
+const defaultKillGrace = 10 * time.Second
+
+// ShellRunner runs commands via bash -lc (login shell for env vars), another
+// shell given in Options, or directly when Options has an Argv. Each command
+// runs in its own process group so that a timeout stops everything bash
+// started, not just bash itself.
+type ShellRunner struct {
+	// KillGrace overrides how long to wait between SIGTERM and SIGKILL.
+	KillGrace time.Duration
+}
+
+// commandLine returns the program and arguments that run cmd with opts.
+func commandLine(cmd string, opts Options) []string {
+	switch {
+	case len(opts.Argv) > 0:
+		return opts.Argv
+	case len(opts.Shell) > 0:
+		return append(opts.Shell[:len(opts.Shell):len(opts.Shell)], cmd)
+	default:
+		return []string{"bash", "-lc", cmd}
+	}
+}
+
+func (s ShellRunner) Run(cmd string, dir string, opts Options) (string, error) {
+	args := commandLine(cmd, opts)
+	c := exec.Command(args[0], args[1:]...)
+	if dir != "" {
+		c.Dir = dir
+	}
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: opts.Credential}
+	c.Env = opts.Env
+	if opts.Stdin != nil {
+		c.Stdin = bytes.NewReader(opts.Stdin)
+	}
+
+	out := &activityBuffer{lastWrite: time.Now()}
+	c.Stdout = out
+	c.Stderr = out
+	if opts.Stdout != nil {
+		c.Stdout = io.MultiWriter(out, opts.Stdout)
+	}
+
+	if err := c.Start(); err != nil {
+		return "", err
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Wait() }()
+
+	var deadline, idle <-chan time.Time
+	if opts.Timeout > 0 {
+		t := time.NewTimer(opts.Timeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+	var idleTimer *time.Timer
+	if opts.IdleTimeout > 0 {
+		idleTimer = time.NewTimer(opts.IdleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+
+	for {
+		select {
+		case err := <-done:
+			return out.String(), err
+		case <-deadline:
+			s.terminate(c, done)
+			return out.String(), &TimeoutError{After: opts.Timeout}
+		case <-idle:
+			quiet := out.quietFor()
+			if quiet < opts.IdleTimeout {
+				idleTimer.Reset(opts.IdleTimeout - quiet)
+				continue
+			}
+			s.terminate(c, done)
+			return out.String(), &TimeoutError{Idle: true, After: opts.IdleTimeout}
+		}
+	}
+}
+
+// terminate sends SIGTERM to the command's process group, then SIGKILL if it
+// hasn't exited within the grace period. It returns once the command has exited.
+func (s ShellRunner) terminate(c *exec.Cmd, done <-chan error) {
+	grace := s.KillGrace
+	if grace <= 0 {
+		grace = defaultKillGrace
+	}
+
+	pgid := -c.Process.Pid
+	syscall.Kill(pgid, syscall.SIGTERM)
+	select {
+	case <-done:
+		return
+	case <-time.After(grace):
+	}
+	syscall.Kill(pgid, syscall.SIGKILL)
+	<-done
+}
+
+// activityBuffer collects output and remembers when it last received any.
+type activityBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	lastWrite time.Time
+}
+
+func (b *activityBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastWrite = time.Now()
+	return b.buf.Write(p)
+}
+
+func (b *activityBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *activityBuffer) quietFor() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Since(b.lastWrite)
+}
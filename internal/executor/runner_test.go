@@ -0,0 +1,180 @@
+package executor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// plainShell skips the login profile that the default bash -lc loads, so the
+// timing tests measure the runner rather than how slow the profile is.
+var plainShell = []string{"sh", "-c"}
+
+func TestShellRunner_Timeout(t *testing.T) {
+	runner := ShellRunner{}
+
+	start := time.Now()
+	_, err := runner.Run("sleep 30", "", Options{Timeout: 200 * time.Millisecond, Shell: plainShell})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected command to be stopped quickly, took %s", elapsed)
+	}
+
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) {
+		t.Fatalf("expected TimeoutError, got: %v", err)
+	}
+	if timeout.Idle {
+		t.Error("expected a total timeout, got an idle timeout")
+	}
+}
+
+func TestShellRunner_IdleTimeout(t *testing.T) {
+	runner := ShellRunner{}
+
+	output, err := runner.Run("echo started; sleep 30", "", Options{IdleTimeout: 300 * time.Millisecond, Shell: plainShell})
+
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) {
+		t.Fatalf("expected TimeoutError, got: %v", err)
+	}
+	if !timeout.Idle {
+		t.Error("expected an idle timeout, got a total timeout")
+	}
+	if !strings.Contains(output, "started") {
+		t.Errorf("expected output before the timeout to be kept, got %q", output)
+	}
+}
+
+func TestShellRunner_IdleTimeoutResetByOutput(t *testing.T) {
+	runner := ShellRunner{}
+
+	_, err := runner.Run("for i in 1 2 3 4 5 6; do echo $i; sleep 0.1; done", "", Options{IdleTimeout: 400 * time.Millisecond, Shell: plainShell})
+	if err != nil {
+		t.Fatalf("expected steady output to keep the command alive, got: %v", err)
+	}
+}
+
+func TestShellRunner_TimeoutKillsProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	runner := ShellRunner{}
+
+	_, err := runner.Run("sleep 30 & echo $! > "+pidFile+"; wait", "", Options{Timeout: 300 * time.Millisecond, Shell: plainShell})
+	if err == nil {
+		t.Fatal("expected timeout error, got nil")
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("failed to read child pid: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatalf("invalid child pid %q: %v", data, err)
+	}
+
+	// The child has been signalled; give the kernel a moment to reap it.
+	deadline := time.Now().Add(2 * time.Second)
+	for syscall.Kill(pid, 0) == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected background child %d to be killed", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestShellRunner_KillAfterGrace(t *testing.T) {
+	runner := ShellRunner{KillGrace: 200 * time.Millisecond}
+
+	start := time.Now()
+	_, err := runner.Run("trap '' TERM; sleep 30", "", Options{Timeout: 200 * time.Millisecond, Shell: plainShell})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected SIGKILL after the grace period, took %s", elapsed)
+	}
+
+	var timeout *TimeoutError
+	if !errors.As(err, &timeout) {
+		t.Fatalf("expected TimeoutError, got: %v", err)
+	}
+}
+
+func TestShellRunner_CustomShell(t *testing.T) {
+	runner := ShellRunner{}
+
+	output, err := runner.Run(`echo "$0"`, "", Options{Shell: []string{"sh", "-c"}})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if strings.TrimSpace(output) != "sh" {
+		t.Errorf("expected command to run under sh, got %q", output)
+	}
+}
+
+func TestShellRunner_Argv(t *testing.T) {
+	runner := ShellRunner{}
+
+	output, err := runner.Run("ignored", "", Options{Argv: []string{"echo", "*", "$HOME", "a  b"}})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if output != "* $HOME a  b\n" {
+		t.Errorf("expected arguments to be passed through untouched, got %q", output)
+	}
+}
+
+func TestShellRunner_Credential(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching users needs root")
+	}
+	runner := ShellRunner{}
+
+	output, err := runner.Run("id -u; id -g", "", Options{
+		Shell:      []string{"sh", "-c"},
+		Credential: &syscall.Credential{Uid: 65534, Gid: 65534},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v (output %q)", err, output)
+	}
+	if output != "65534\n65534\n" {
+		t.Errorf("expected command to run as 65534:65534, got %q", output)
+	}
+}
+
+func TestDryRunner(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"default shell", Options{}, `would run ["bash" "-lc" "touch ` + marker + `"] in ` + dir},
+		{"custom shell", Options{Shell: []string{"sh", "-c"}}, `["sh" "-c" "touch ` + marker + `"]`},
+		{"argv", Options{Argv: []string{"touch", marker}}, `["touch" "` + marker + `"]`},
+		{"credential", Options{Credential: &syscall.Credential{Uid: 1000, Gid: 100}}, "as uid 1000 gid 100"},
+		{"shown", Options{Shown: "touch ${MARKER}"}, `would run "touch ${MARKER}" in ` + dir},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out string
+			var err error
+			logged := captureLog(func() {
+				out, err = DryRunner{}.Run("touch "+marker, dir, tt.opts)
+			})
+			if err != nil || out != "" {
+				t.Errorf("expected success without output, got %q, %v", out, err)
+			}
+			if !strings.Contains(logged, tt.want) {
+				t.Errorf("expected log to contain %q, got:\n%s", tt.want, logged)
+			}
+			if _, err := os.Stat(marker); err == nil {
+				t.Fatal("expected the command not to run")
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package executor
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "docker compose up -d", want: []string{"docker", "compose", "up", "-d"}},
+		{in: "  spaced\tout \n", want: []string{"spaced", "out"}},
+		{in: `echo 'a b' "c d"`, want: []string{"echo", "a b", "c d"}},
+		{in: `echo it's\ fine`, wantErr: true},
+		{in: `echo it\'s\ fine`, want: []string{"echo", "it's fine"}},
+		{in: `echo "say \"hi\" \n"`, want: []string{"echo", `say "hi" \n`}},
+		{in: `echo '' *.txt $HOME`, want: []string{"echo", "", "*.txt", "$HOME"}},
+		{in: `echo a"b"'c'`, want: []string{"echo", "abc"}},
+		{in: `echo "open`, wantErr: true},
+		{in: "", want: nil},
+	}
+
+	for _, tt := range tests {
+		got, err := splitWords(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("splitWords(%q) = %q, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitWords(%q) returned error: %v", tt.in, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -11,13 +11,31 @@ import (
 
 // EventStore manages webhook event deduplication
 type EventStore struct {
-	db *sql.DB
+	db    *sql.DB
+	table string
 }
 
+// Tables that events are recorded in: eventsTable normally, and dryRunTable
+// in dry-run mode, so deliveries seen in a dry run still deploy for real later.
+const (
+	eventsTable = "events"
+	dryRunTable = "dry_run_events"
+)
+
 // NewEventStore creates a new EventStore with the given database path.
 // If dbPath is empty, defaults to "db.sqlite".
 // Use ":memory:" for in-memory databases (testing).
 func NewEventStore(dbPath string) (*EventStore, error) {
+	return openEventStore(dbPath, eventsTable)
+}
+
+// NewDryRunEventStore is like NewEventStore, but records events in a table of
+// their own, apart from those that were deployed.
+func NewDryRunEventStore(dbPath string) (*EventStore, error) {
+	return openEventStore(dbPath, dryRunTable)
+}
+
+func openEventStore(dbPath, table string) (*EventStore, error) {
 	if dbPath == "" {
 		dbPath = "db.sqlite"
 	}
@@ -34,18 +52,18 @@ func NewEventStore(dbPath string) (*EventStore, error) {
 	}
 
 	// Create table if not exists
-	if err := initSchema(db); err != nil {
+	if err := initSchema(db, table); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
 
-	return &EventStore{db: db}, nil
+	return &EventStore{db: db, table: table}, nil
 }
 
-// initSchema creates the events table if it doesn't exist
-func initSchema(db *sql.DB) error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS events (
+// initSchema creates the given events table if it doesn't exist
+func initSchema(db *sql.DB, table string) error {
+	schema := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %[1]s (
 		delivery_id TEXT PRIMARY KEY,
 		tag TEXT NOT NULL,
 		version_id INTEGER NOT NULL,
@@ -53,8 +71,8 @@ func initSchema(db *sql.DB) error {
 		timestamp DATETIME NOT NULL,
 		repository TEXT NOT NULL
 	);
-	CREATE UNIQUE INDEX IF NOT EXISTS idx_events_content_dedup ON events(tag, version_id, sha);
-	`
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_%[1]s_content_dedup ON %[1]s(tag, version_id, sha);
+	`, table)
 
 	_, err := db.Exec(schema)
 	return err
@@ -74,7 +92,7 @@ func (es *EventStore) RecordEvent(deliveryID, tag string, versionID int64, sha,
 	// Check for existing row with same content
 	var exists int
 	err = tx.QueryRow(
-		`SELECT 1 FROM events WHERE tag = ? AND version_id = ? AND sha = ?`,
+		`SELECT 1 FROM `+es.table+` WHERE tag = ? AND version_id = ? AND sha = ?`,
 		tag, versionID, sha,
 	).Scan(&exists)
 	if err == nil {
@@ -87,7 +105,7 @@ func (es *EventStore) RecordEvent(deliveryID, tag string, versionID int64, sha,
 
 	// Insert new event
 	_, err = tx.Exec(
-		`INSERT INTO events (delivery_id, tag, version_id, sha, timestamp, repository) VALUES (?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO `+es.table+` (delivery_id, tag, version_id, sha, timestamp, repository) VALUES (?, ?, ?, ?, ?, ?)`,
 		deliveryID, tag, versionID, sha, time.Now().UTC(), repository,
 	)
 	if err != nil {
@@ -122,6 +140,6 @@ func (es *EventStore) Close() error {
 
 // Stats returns statistics about stored events (useful for monitoring)
 func (es *EventStore) Stats() (total int, err error) {
-	err = es.db.QueryRow("SELECT COUNT(*) FROM events").Scan(&total)
+	err = es.db.QueryRow("SELECT COUNT(*) FROM " + es.table).Scan(&total)
 	return
 }
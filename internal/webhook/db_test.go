@@ -209,3 +209,35 @@ func TestRecordEvent_ConcurrentInserts(t *testing.T) {
 		t.Errorf("Expected 1 event after concurrent inserts, got %d", count)
 	}
 }
+
+func TestNewDryRunEventStore_SeparateTable(t *testing.T) {
+	dbPath := t.TempDir() + "/test_db.sqlite"
+	dryRun, err := NewDryRunEventStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create dry-run event store: %v", err)
+	}
+	defer dryRun.Close()
+
+	isNew, err := dryRun.RecordEvent("delivery-1", "latest", 675688875, "sha256:abc123", "test-repo")
+	if err != nil || !isNew {
+		t.Fatalf("Expected the dry run to record a new event, got isNew=%v err=%v", isNew, err)
+	}
+	isNew, err = dryRun.RecordEvent("delivery-2", "latest", 675688875, "sha256:abc123", "test-repo")
+	if err != nil || isNew {
+		t.Errorf("Expected the dry run to deduplicate, got isNew=%v err=%v", isNew, err)
+	}
+
+	store, err := NewEventStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create event store: %v", err)
+	}
+	defer store.Close()
+
+	isNew, err = store.RecordEvent("delivery-1", "latest", 675688875, "sha256:abc123", "test-repo")
+	if err != nil || !isNew {
+		t.Errorf("Expected an event seen in a dry run to still be new, got isNew=%v err=%v", isNew, err)
+	}
+	if total, _ := dryRun.Stats(); total != 1 {
+		t.Errorf("Expected 1 dry-run event, got %d", total)
+	}
+}
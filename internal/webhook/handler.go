@@ -2,6 +2,7 @@ package webhook
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -12,11 +13,14 @@ import (
 )
 
 // Handler returns an HTTP handler for registry_package webhook events.
-// The secret is used to verify the webhook signature.
-// The cfg parameter contains the package-to-commands mapping.
+// A signature made with any of the current secrets is accepted, and the key
+// ID of the one that matched is logged; like the config, the secrets are read
+// afresh for every event.
+// The cfg parameter supplies the package-to-commands mapping; it is read
+// afresh for every event so that a reloaded config takes effect immediately.
 // The store is used for webhook event deduplication.
 // The runner is used to execute commands.
-func Handler(secret []byte, cfg config.Config, store *EventStore, runner executor.Runner) http.HandlerFunc {
+func Handler(secrets SecretProvider, cfg config.Provider, store *EventStore, runner executor.Runner) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Received %s request from %s", r.Method, r.RemoteAddr)
 
@@ -55,38 +59,29 @@ func Handler(secret []byte, cfg config.Config, store *EventStore, runner executo
 			return
 		}
 
-		if !VerifySignature(body, signature, secret) {
+		secret, ok := VerifySignature(body, signature, secrets.Current())
+		if !ok {
 			log.Printf("Signature verification failed - received: %s", signature)
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
+		log.Printf("Signature verified with secret key ID %s", secret.KeyID)
 
-		// Check if this is a ping event
-		var rawEvent map[string]interface{}
-		if err := json.Unmarshal(body, &rawEvent); err != nil {
-			log.Printf("Failed to parse JSON payload: %v", err)
+		d, err := decide(body, cfg.Current())
+		if err != nil {
+			log.Printf("Failed to parse %v", err)
 			http.Error(w, "Bad Request", http.StatusBadRequest)
 			return
 		}
-
-		// Handle ping events
-		if zen, ok := rawEvent["zen"].(string); ok {
-			log.Printf("✓ Received ping event: %s", zen)
+		if d.ping {
+			log.Printf("✓ Received ping event: %s", d.zen)
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 
-		var event RegistryPackageEvent
-		if err := json.Unmarshal(body, &event); err != nil {
-			log.Printf("Failed to parse registry_package event: %v", err)
-			http.Error(w, "Bad Request", http.StatusBadRequest)
-			return
-		}
-
-		// Tag filter: only process "latest" tags
-		tagName := event.RegistryPackage.PackageVersion.ContainerMetadata.Tag.Name
-		if tagName != "latest" {
-			log.Printf("Ignoring non-latest tag: %s", tagName)
+		event, packageName, tagName, pkg := d.event, d.packageName, d.tag, d.pkg
+		if !d.accepted {
+			log.Printf("Ignoring tag %s for package %s: no matching tag pattern", tagName, packageName)
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -98,8 +93,8 @@ func Handler(secret []byte, cfg config.Config, store *EventStore, runner executo
 		} else {
 			versionID := event.RegistryPackage.PackageVersion.ID
 			sha := event.RegistryPackage.PackageVersion.Version
-			packageName := event.RegistryPackage.Name
 
+			// Record the tag that matched, so each accepted tag is deduplicated separately
 			isNew, err := store.RecordEvent(deliveryID, tagName, versionID, sha, packageName)
 			if err != nil {
 				log.Printf("Database error while recording event: %v", err)
@@ -121,12 +116,10 @@ func Handler(secret []byte, cfg config.Config, store *EventStore, runner executo
 			event.RegistryPackage.Name,
 			event.RegistryPackage.PackageVersion.Version)
 
-		packageName := event.RegistryPackage.Name
-		dirCommands := cfg.GetRun(packageName)
-
-		if len(dirCommands) > 0 {
-			log.Printf("✓ Found commands for package %s in %d director(ies)", packageName, len(dirCommands))
-			go executor.Execute(runner, packageName, deliveryID, dirCommands)
+		if pkg.HasCommands() {
+			dirs := len(pkg.Setup) + len(pkg.Run) + len(pkg.Teardown)
+			log.Printf("✓ Found commands for package %s in %d director(ies)", packageName, dirs)
+			go executor.Execute(runner, event.Event(deliveryID, body), pkg)
 		} else {
 			log.Printf("No commands configured for package %s", packageName)
 		}
@@ -134,3 +127,42 @@ func Handler(secret []byte, cfg config.Config, store *EventStore, runner executo
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+// decision is what the handler makes of a verified payload, before deduplication.
+type decision struct {
+	ping        bool // a ping event, which has nothing more than its zen
+	zen         string
+	event       RegistryPackageEvent
+	packageName string
+	tag         string
+	pkg         config.PackageConfig // what to run for the tag
+	accepted    bool                 // whether the package reacts to the tag
+}
+
+// decide parses a payload and works out whether the package reacts to its
+// tag and, if so, which run block applies.
+func decide(body []byte, cfg config.Config) (decision, error) {
+	// Check if this is a ping event
+	var rawEvent map[string]interface{}
+	if err := json.Unmarshal(body, &rawEvent); err != nil {
+		return decision{}, fmt.Errorf("JSON payload: %w", err)
+	}
+	if zen, ok := rawEvent["zen"].(string); ok {
+		return decision{ping: true, zen: zen}, nil
+	}
+
+	var event RegistryPackageEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return decision{}, fmt.Errorf("registry_package event: %w", err)
+	}
+
+	// Tag filter: only process tags the package is configured for ("latest" by default),
+	// picking the run block for the matching tag
+	d := decision{
+		event:       event,
+		packageName: event.RegistryPackage.Name,
+		tag:         event.RegistryPackage.PackageVersion.ContainerMetadata.Tag.Name,
+	}
+	d.pkg, d.accepted = cfg.GetRun(d.packageName, d.tag)
+	return d, nil
+}
@@ -1,16 +1,20 @@
 package webhook
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/jc/steakpie/internal/config"
 	"github.com/jc/steakpie/internal/executor"
@@ -18,29 +22,31 @@ import (
 
 var testSecret = []byte("test-secret")
 
+var testSecrets = Secrets{{KeyID: "1", Key: testSecret}}
+
 var testConfig = config.Config{
 	"test-package": {
-		Run: map[string][]config.Command{
-			"/opt/test": {
+		Run: config.Directories{
+			{Dir: "/opt/test", Commands: []config.Command{
 				{Cmd: "echo test"},
 				{Cmd: "docker compose up"},
-			},
+			}},
 		},
 	},
 	"jamiec": {
-		Run: map[string][]config.Command{
-			"/opt/jamiec": {
+		Run: config.Directories{
+			{Dir: "/opt/jamiec", Commands: []config.Command{
 				{Cmd: "docker compose down"},
 				{Cmd: "docker compose up"},
-			},
+			}},
 		},
 	},
 	"hello-world": {
-		Run: map[string][]config.Command{
-			"/opt/hello": {
+		Run: config.Directories{
+			{Dir: "/opt/hello", Commands: []config.Command{
 				{Cmd: "echo hello"},
 				{Cmd: "docker pull hello-world"},
-			},
+			}},
 		},
 	},
 }
@@ -48,7 +54,7 @@ var testConfig = config.Config{
 // noopRunner is a no-op runner for handler tests.
 type noopRunner struct{}
 
-func (noopRunner) Run(cmd string, dir string) (string, error) { return "", nil }
+func (noopRunner) Run(cmd string, dir string, opts executor.Options) (string, error) { return "", nil }
 
 var testRunner executor.Runner = noopRunner{}
 
@@ -81,7 +87,7 @@ func TestHandler_ValidPayload(t *testing.T) {
 	req.Header.Set("X-Hub-Signature-256", signPayload(payload, testSecret))
 	rec := httptest.NewRecorder()
 
-	Handler(testSecret, testConfig, store, testRunner).ServeHTTP(rec, req)
+	Handler(testSecrets, testConfig, store, testRunner).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
@@ -98,7 +104,7 @@ func TestHandler_ValidSignature(t *testing.T) {
 	req.Header.Set("X-Hub-Signature-256", signPayload(payload, testSecret))
 	rec := httptest.NewRecorder()
 
-	Handler(testSecret, testConfig, store, testRunner).ServeHTTP(rec, req)
+	Handler(testSecrets, testConfig, store, testRunner).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
@@ -115,7 +121,7 @@ func TestHandler_InvalidSignature(t *testing.T) {
 	req.Header.Set("X-Hub-Signature-256", "sha256=invalidsignature00000000000000000000000000000000000000000000000000")
 	rec := httptest.NewRecorder()
 
-	Handler(testSecret, testConfig, store, testRunner).ServeHTTP(rec, req)
+	Handler(testSecrets, testConfig, store, testRunner).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusForbidden {
 		t.Errorf("expected status %d, got %d", http.StatusForbidden, rec.Code)
@@ -131,7 +137,7 @@ func TestHandler_MissingSignature(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	rec := httptest.NewRecorder()
 
-	Handler(testSecret, testConfig, store, testRunner).ServeHTTP(rec, req)
+	Handler(testSecrets, testConfig, store, testRunner).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusForbidden {
 		t.Errorf("expected status %d, got %d", http.StatusForbidden, rec.Code)
@@ -148,7 +154,7 @@ func TestHandler_InvalidJSON(t *testing.T) {
 	req.Header.Set("X-Hub-Signature-256", signPayload(payload, testSecret))
 	rec := httptest.NewRecorder()
 
-	Handler(testSecret, testConfig, store, testRunner).ServeHTTP(rec, req)
+	Handler(testSecrets, testConfig, store, testRunner).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
@@ -165,7 +171,7 @@ func TestHandler_MethodNotAllowed(t *testing.T) {
 			req := httptest.NewRequest(method, "/version/1", nil)
 			rec := httptest.NewRecorder()
 
-			Handler(testSecret, testConfig, store, testRunner).ServeHTTP(rec, req)
+			Handler(testSecrets, testConfig, store, testRunner).ServeHTTP(rec, req)
 
 			if rec.Code != http.StatusMethodNotAllowed {
 				t.Errorf("expected status %d for %s, got %d", http.StatusMethodNotAllowed, method, rec.Code)
@@ -184,7 +190,7 @@ func TestHandler_EmptyBody(t *testing.T) {
 	req.Header.Set("X-Hub-Signature-256", signPayload(payload, testSecret))
 	rec := httptest.NewRecorder()
 
-	Handler(testSecret, testConfig, store, testRunner).ServeHTTP(rec, req)
+	Handler(testSecrets, testConfig, store, testRunner).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
@@ -201,7 +207,7 @@ func TestHandler_MissingFields(t *testing.T) {
 	req.Header.Set("X-Hub-Signature-256", signPayload(payload, testSecret))
 	rec := httptest.NewRecorder()
 
-	Handler(testSecret, testConfig, store, testRunner).ServeHTTP(rec, req)
+	Handler(testSecrets, testConfig, store, testRunner).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
@@ -218,7 +224,7 @@ func TestHandler_PingEvent(t *testing.T) {
 	req.Header.Set("X-Hub-Signature-256", signPayload(payload, testSecret))
 	rec := httptest.NewRecorder()
 
-	Handler(testSecret, testConfig, store, testRunner).ServeHTTP(rec, req)
+	Handler(testSecrets, testConfig, store, testRunner).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
@@ -240,7 +246,7 @@ func TestHandler_FormEncodedRejected(t *testing.T) {
 	req.Header.Set("X-Hub-Signature-256", signPayload([]byte(formBody), testSecret))
 	rec := httptest.NewRecorder()
 
-	Handler(testSecret, testConfig, store, testRunner).ServeHTTP(rec, req)
+	Handler(testSecrets, testConfig, store, testRunner).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusUnsupportedMediaType {
 		t.Errorf("expected status %d, got %d: %s", http.StatusUnsupportedMediaType, rec.Code, rec.Body.String())
@@ -265,13 +271,42 @@ func TestHandler_ConfiguredPackage(t *testing.T) {
 	req.Header.Set("X-Hub-Signature-256", signPayload(payload, testSecret))
 	rec := httptest.NewRecorder()
 
-	Handler(testSecret, testConfig, store, testRunner).ServeHTTP(rec, req)
+	Handler(testSecrets, testConfig, store, testRunner).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
 	}
 }
 
+func TestHandler_CountsEveryPhase(t *testing.T) {
+	store := createTestStore(t)
+	cfg := config.Config{
+		"hello-world": {
+			Setup:    config.Directories{{Dir: "/opt/db", Commands: []config.Command{{Cmd: "migrate"}}}},
+			Teardown: config.Directories{{Dir: "/opt/cache", Commands: []config.Command{{Cmd: "flush"}}}},
+		},
+	}
+
+	payload, err := os.ReadFile("../../testdata/registry_package_published.json")
+	if err != nil {
+		t.Fatalf("failed to read test payload: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/version/1", strings.NewReader(string(payload)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hub-Signature-256", signPayload(payload, testSecret))
+	rec := httptest.NewRecorder()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	Handler(testSecrets, cfg, store, testRunner).ServeHTTP(rec, req)
+	log.SetOutput(os.Stderr)
+
+	if want := "Found commands for package hello-world in 2 director(ies)"; !strings.Contains(logs.String(), want) {
+		t.Errorf("expected log to contain %q, got:\n%s", want, logs.String())
+	}
+}
+
 func TestHandler_UnconfiguredPackage(t *testing.T) {
 	store := createTestStore(t)
 
@@ -298,7 +333,7 @@ func TestHandler_UnconfiguredPackage(t *testing.T) {
 	req.Header.Set("X-Hub-Signature-256", signPayload(payload, testSecret))
 	rec := httptest.NewRecorder()
 
-	Handler(testSecret, testConfig, store, testRunner).ServeHTTP(rec, req)
+	Handler(testSecrets, testConfig, store, testRunner).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
@@ -322,7 +357,7 @@ func TestHandler_Idempotency_DuplicateEvent(t *testing.T) {
 	deliveryID := "test-delivery-001"
 
 	// Create handler
-	handler := Handler(testSecret, testConfig, store, testRunner)
+	handler := Handler(testSecrets, testConfig, store, testRunner)
 
 	// First request
 	req1 := httptest.NewRequest(http.MethodPost, "/version/1", strings.NewReader(string(payload)))
@@ -366,7 +401,7 @@ func TestHandler_Idempotency_DifferentEvents(t *testing.T) {
 		t.Fatalf("failed to read test payload: %v", err)
 	}
 
-	handler := Handler(testSecret, testConfig, store, testRunner)
+	handler := Handler(testSecrets, testConfig, store, testRunner)
 
 	deliveryIDs := []string{"delivery-001", "delivery-002", "delivery-003"}
 
@@ -404,7 +439,7 @@ func TestHandler_Idempotency_MissingDeliveryID(t *testing.T) {
 	// Intentionally NOT setting X-GitHub-Delivery header
 	rec := httptest.NewRecorder()
 
-	Handler(testSecret, testConfig, store, testRunner).ServeHTTP(rec, req)
+	Handler(testSecrets, testConfig, store, testRunner).ServeHTTP(rec, req)
 
 	// Should still return 200 (backwards compatibility)
 	if rec.Code != http.StatusOK {
@@ -438,7 +473,7 @@ func TestHandler_NonLatestTagIgnored(t *testing.T) {
 	req.Header.Set("X-GitHub-Delivery", "non-latest-delivery")
 	rec := httptest.NewRecorder()
 
-	Handler(testSecret, testConfig, store, testRunner).ServeHTTP(rec, req)
+	Handler(testSecrets, testConfig, store, testRunner).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
@@ -467,7 +502,7 @@ func TestHandler_ContentDedupDifferentDeliveryIDs(t *testing.T) {
 		t.Fatalf("failed to read test payload: %v", err)
 	}
 
-	handler := Handler(testSecret, testConfig, store, testRunner)
+	handler := Handler(testSecrets, testConfig, store, testRunner)
 
 	// Send 5 webhooks with different delivery IDs but same content
 	for i := range 5 {
@@ -506,7 +541,7 @@ func TestHandler_Idempotency_PingEventsNotDeduplicated(t *testing.T) {
 	payload := []byte(`{"zen": "Design for failure.", "hook_id": 123}`)
 	deliveryID := "ping-delivery-001"
 
-	handler := Handler(testSecret, testConfig, store, testRunner)
+	handler := Handler(testSecrets, testConfig, store, testRunner)
 
 	// Send ping event twice with same delivery ID
 	for i := 0; i < 2; i++ {
@@ -523,3 +558,268 @@ func TestHandler_Idempotency_PingEventsNotDeduplicated(t *testing.T) {
 		}
 	}
 }
+
+func TestHandler_ConfiguredTagPatterns(t *testing.T) {
+	store := createTestStore(t)
+
+	mustPattern := func(s string) config.TagPattern {
+		p, err := config.ParseTagPattern(s)
+		if err != nil {
+			t.Fatalf("invalid pattern %q: %v", s, err)
+		}
+		return p
+	}
+	cfg := config.Config{
+		"hello-world": {
+			Tags: config.TagRules{{Pattern: mustPattern("staging")}, {Pattern: mustPattern(">=1.2.0 <2")}},
+		},
+	}
+
+	tagPayload := func(tag string, versionID int) []byte {
+		return []byte(fmt.Sprintf(`{
+			"action": "published",
+			"registry_package": {
+				"name": "hello-world",
+				"package_version": {
+					"id": %d,
+					"version": "sha256:abc123def456",
+					"container_metadata": {"tag": {"name": %q, "digest": "sha256:abc123"}}
+				}
+			}
+		}`, versionID, tag))
+	}
+
+	handler := Handler(testSecrets, cfg, store, testRunner)
+	for i, tag := range []string{"latest", "v1.2.3", "staging", "v2.0.0"} {
+		payload := tagPayload(tag, i)
+		req := httptest.NewRequest(http.MethodPost, "/version/1", strings.NewReader(string(payload)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Hub-Signature-256", signPayload(payload, testSecret))
+		req.Header.Set("X-GitHub-Delivery", fmt.Sprintf("tag-delivery-%d", i))
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("tag %s: expected status %d, got %d", tag, http.StatusOK, rec.Code)
+		}
+	}
+
+	rows, err := store.db.Query("SELECT tag FROM events ORDER BY version_id")
+	if err != nil {
+		t.Fatalf("failed to query events: %v", err)
+	}
+	defer rows.Close()
+
+	var recorded []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			t.Fatalf("failed to scan tag: %v", err)
+		}
+		recorded = append(recorded, tag)
+	}
+
+	if strings.Join(recorded, ",") != "v1.2.3,staging" {
+		t.Errorf("expected matched tags v1.2.3 and staging to be recorded, got %v", recorded)
+	}
+}
+
+// chanRunner reports the directory of every command it is asked to run.
+type chanRunner chan string
+
+func (c chanRunner) Run(cmd string, dir string, opts executor.Options) (string, error) {
+	c <- dir
+	return "", nil
+}
+
+func TestHandler_DispatchesTagBlock(t *testing.T) {
+	store := createTestStore(t)
+
+	staging, err := config.ParseTagPattern("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := config.ParseTagPattern("latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Config{
+		"hello-world": {
+			Tags: config.TagRules{
+				{Pattern: staging, Override: true, Run: config.Directories{
+					{Dir: "/srv/staging", Commands: []config.Command{{Cmd: "docker compose up -d"}}},
+				}},
+				{Pattern: latest, Override: true, Run: config.Directories{
+					{Dir: "/srv/prod", Commands: []config.Command{{Cmd: "docker compose up -d"}}},
+				}},
+			},
+		},
+	}
+
+	payload := []byte(`{
+		"action": "published",
+		"registry_package": {
+			"name": "hello-world",
+			"package_version": {
+				"id": 1,
+				"version": "sha256:abc123def456",
+				"container_metadata": {"tag": {"name": "staging", "digest": "sha256:abc123"}}
+			}
+		}
+	}`)
+
+	runner := make(chanRunner, 1)
+	req := httptest.NewRequest(http.MethodPost, "/version/1", strings.NewReader(string(payload)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hub-Signature-256", signPayload(payload, testSecret))
+	rec := httptest.NewRecorder()
+
+	Handler(testSecrets, cfg, store, runner).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	select {
+	case dir := <-runner:
+		if dir != "/srv/staging" {
+			t.Errorf("expected staging block to run in /srv/staging, got %s", dir)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the staging block to be dispatched")
+	}
+}
+
+// optsRunner reports the options of every command it is asked to run.
+type optsRunner chan executor.Options
+
+func (c optsRunner) Run(cmd string, dir string, opts executor.Options) (string, error) {
+	c <- opts
+	return "", nil
+}
+
+func TestHandler_PassesEventToCommands(t *testing.T) {
+	store := createTestStore(t)
+
+	payload := []byte(`{
+		"action": "published",
+		"registry_package": {
+			"name": "hello-world",
+			"package_version": {
+				"id": 7,
+				"version": "sha256:abc123def456",
+				"container_metadata": {"tag": {"name": "latest", "digest": "sha256:abc123"}}
+			}
+		},
+		"repository": {"full_name": "octo/hello-world"},
+		"sender": {"login": "octocat"}
+	}`)
+
+	runner := make(optsRunner, 2)
+	req := httptest.NewRequest(http.MethodPost, "/version/1", strings.NewReader(string(payload)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hub-Signature-256", signPayload(payload, testSecret))
+	req.Header.Set("X-GitHub-Delivery", "delivery-env")
+	rec := httptest.NewRecorder()
+
+	Handler(testSecrets, testConfig, store, runner).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	select {
+	case opts := <-runner:
+		env := strings.Join(opts.Env, "\n")
+		for _, want := range []string{
+			"STEAKPIE_PACKAGE=hello-world",
+			"STEAKPIE_TAG=latest",
+			"STEAKPIE_SHA=sha256:abc123def456",
+			"STEAKPIE_VERSION_ID=7",
+			"STEAKPIE_DELIVERY_ID=delivery-env",
+			"STEAKPIE_REPOSITORY=octo/hello-world",
+			"STEAKPIE_SENDER=octocat",
+			"STEAKPIE_PAYLOAD_FILE=",
+		} {
+			if !strings.Contains(env, want) {
+				t.Errorf("expected command env to contain %s, got:\n%s", want, env)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected commands to be dispatched")
+	}
+	<-runner
+}
+
+func TestHandler_RotatedSecrets(t *testing.T) {
+	store := createTestStore(t)
+	oldSecret, newSecret := Secret{KeyID: "old", Key: []byte("old-secret")}, Secret{KeyID: "new", Key: []byte("new-secret")}
+	handler := Handler(Secrets{oldSecret, newSecret}, testConfig, store, testRunner)
+	payload := []byte(`{"zen": "Design for failure."}`)
+
+	for _, secret := range []Secret{oldSecret, newSecret} {
+		req := httptest.NewRequest(http.MethodPost, "/version/1", strings.NewReader(string(payload)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Hub-Signature-256", signPayload(payload, secret.Key))
+		rec := httptest.NewRecorder()
+
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		handler.ServeHTTP(rec, req)
+		log.SetOutput(os.Stderr)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected status %d for key %s, got %d", http.StatusOK, secret.KeyID, rec.Code)
+		}
+		if want := "Signature verified with secret key ID " + secret.KeyID; !strings.Contains(logs.String(), want) {
+			t.Errorf("expected log to contain %q, got:\n%s", want, logs.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/version/1", strings.NewReader(string(payload)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hub-Signature-256", signPayload(payload, []byte("retired-secret")))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for a retired secret, got %d", http.StatusForbidden, rec.Code)
+	}
+}
+
+func TestHandler_ReloadedSecrets(t *testing.T) {
+	store := createTestStore(t)
+	path := filepath.Join(t.TempDir(), "secrets")
+	if err := os.WriteFile(path, []byte("old-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	initial, err := ReadSecrets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := NewLiveSecrets(path, initial)
+	handler := Handler(live, testConfig, store, testRunner)
+	payload := []byte(`{"zen": "Keep it logically awesome."}`)
+
+	send := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/version/1", strings.NewReader(string(payload)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Hub-Signature-256", signPayload(payload, []byte("new-secret")))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send(); code != http.StatusForbidden {
+		t.Fatalf("expected status %d before the new secret is added, got %d", http.StatusForbidden, code)
+	}
+	if err := os.WriteFile(path, []byte("old-secret\nnew-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := live.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if code := send(); code != http.StatusOK {
+		t.Errorf("expected status %d once the secrets are reloaded, got %d", http.StatusOK, code)
+	}
+}
@@ -0,0 +1,55 @@
+package webhook
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jc/steakpie/internal/config"
+	"github.com/jc/steakpie/internal/executor"
+)
+
+// Plan writes what the handler would do with a verified payload: whether the
+// package reacts to the tag, which of its tag rules matched, and the commands
+// it would run. Nothing runs and no delivery is recorded.
+func Plan(w io.Writer, body []byte, deliveryID string, cfg config.Config) error {
+	d, err := decide(body, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to parse %w", err)
+	}
+	if d.ping {
+		fmt.Fprintf(w, "Ping event (%s): nothing to run\n", d.zen)
+		return nil
+	}
+
+	fmt.Fprintf(w, "Package %s, tag %s (%s event)\n", d.packageName, d.tag, d.event.Action)
+	full, configured := cfg.Get(d.packageName)
+	rule, matched := full.Rule(d.tag)
+	switch {
+	case !configured && !d.accepted:
+		fmt.Fprintf(w, "Ignored: the package isn't configured, so there is nothing to run\n")
+		return nil
+	case !configured:
+		fmt.Fprintf(w, "Accepted: the package isn't configured, so there is nothing to run\n")
+		return nil
+	case !d.accepted && len(full.Tags) == 0:
+		fmt.Fprintf(w, "Ignored: the package has no tag patterns, so it only reacts to latest\n")
+		return nil
+	case !d.accepted:
+		fmt.Fprintf(w, "Ignored: no tag pattern of the package matches %s\n", d.tag)
+		return nil
+	case matched && rule.Override:
+		fmt.Fprintf(w, "Accepted: tag pattern %s matches, running its own phases\n", rule.Pattern)
+	case matched:
+		fmt.Fprintf(w, "Accepted: tag pattern %s matches, running the package's phases\n", rule.Pattern)
+	default:
+		fmt.Fprintf(w, "Accepted: the package has no tag patterns and reacts to latest\n")
+	}
+
+	if !d.pkg.HasCommands() {
+		fmt.Fprintf(w, "No commands configured for package %s\n", d.packageName)
+		return nil
+	}
+	fmt.Fprintln(w)
+	executor.Plan(w, d.event.Event(deliveryID, body), d.pkg)
+	return nil
+}
@@ -0,0 +1,112 @@
+package webhook
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jc/steakpie/internal/config"
+)
+
+func TestPlan(t *testing.T) {
+	payload, err := os.ReadFile("../../testdata/registry_package_published.json")
+	if err != nil {
+		t.Fatalf("Failed to read test payload: %v", err)
+	}
+	pattern := func(s string) config.TagPattern {
+		p, err := config.ParseTagPattern(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	run := config.Directories{{Dir: "/srv/app", Commands: []config.Command{{Cmd: "deploy {{.Tag}} {{.DeliveryID}}"}}}}
+
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want []string
+	}{
+		{
+			name: "own phases",
+			cfg:  config.Config{"hello-world": {Run: run}},
+			want: []string{
+				"Package hello-world, tag latest (published event)",
+				"Accepted: the package has no tag patterns and reacts to latest",
+				"    1. deploy latest plan-1",
+			},
+		},
+		{
+			name: "tag rule with its own phases",
+			cfg: config.Config{"hello-world": {Tags: config.TagRules{
+				{Pattern: pattern("v*")},
+				{Pattern: pattern("lat*"), Override: true, Run: run},
+			}}},
+			want: []string{"Accepted: tag pattern lat* matches, running its own phases", "/srv/app"},
+		},
+		{
+			name: "ignored tag",
+			cfg:  config.Config{"hello-world": {Tags: config.TagRules{{Pattern: pattern("v*")}}, Run: run}},
+			want: []string{"Ignored: no tag pattern of the package matches latest"},
+		},
+		{
+			name: "unconfigured package",
+			cfg:  config.Config{"other": {Run: run}},
+			want: []string{"Accepted: the package isn't configured, so there is nothing to run"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := Plan(&out, payload, "plan-1", tt.cfg); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("expected plan to contain %q, got:\n%s", want, out.String())
+				}
+			}
+			if strings.Contains(out.String(), "Ignored") && strings.Contains(out.String(), "deploy") {
+				t.Errorf("expected no commands for an ignored tag, got:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestPlan_UnconfiguredPackageWithOtherTag(t *testing.T) {
+	payload, err := os.ReadFile("../../testdata/registry_package_published.json")
+	if err != nil {
+		t.Fatalf("Failed to read test payload: %v", err)
+	}
+	payload = []byte(strings.ReplaceAll(string(payload), `"name": "latest"`, `"name": "v1.2.0"`))
+
+	var out strings.Builder
+	if err := Plan(&out, payload, "plan-1", config.Config{"other": {}}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if want := "Ignored: the package isn't configured, so there is nothing to run"; !strings.Contains(out.String(), want) {
+		t.Errorf("expected plan to contain %q, got:\n%s", want, out.String())
+	}
+	if !strings.Contains(out.String(), "tag v1.2.0") {
+		t.Errorf("expected the payload's tag to be v1.2.0, got:\n%s", out.String())
+	}
+}
+
+func TestPlan_Ping(t *testing.T) {
+	var out strings.Builder
+	if err := Plan(&out, []byte(`{"zen": "Keep it logically awesome."}`), "", config.Config{}); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !strings.Contains(out.String(), "Ping event (Keep it logically awesome.)") {
+		t.Errorf("expected a ping to be recognised, got:\n%s", out.String())
+	}
+}
+
+func TestPlan_InvalidPayload(t *testing.T) {
+	var out strings.Builder
+	err := Plan(&out, []byte("not json"), "", config.Config{})
+	if err == nil || !strings.Contains(err.Error(), "failed to parse JSON payload") {
+		t.Errorf("expected a parse error, got: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package webhook
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// SecretProvider supplies the secrets to check the next webhook's signature with.
+type SecretProvider interface {
+	Current() []Secret
+}
+
+// Secrets is a fixed list of secrets, usable as a SecretProvider.
+type Secrets []Secret
+
+// Current returns the secrets themselves.
+func (s Secrets) Current() []Secret {
+	return s
+}
+
+// LiveSecrets holds the secrets currently in use and swaps them atomically
+// when the secret file is reloaded, so a secret can be added or retired
+// without a restart.
+type LiveSecrets struct {
+	path    string
+	mu      sync.Mutex // serialises reloads
+	secrets atomic.Pointer[[]Secret]
+}
+
+// NewLiveSecrets returns LiveSecrets serving secrets, which were read from path.
+func NewLiveSecrets(path string, secrets []Secret) *LiveSecrets {
+	l := &LiveSecrets{path: path}
+	l.secrets.Store(&secrets)
+	return l
+}
+
+// Current returns the secrets currently in use.
+func (l *LiveSecrets) Current() []Secret {
+	return *l.secrets.Load()
+}
+
+// Path returns the secret file the secrets are read from.
+func (l *LiveSecrets) Path() string {
+	return l.path
+}
+
+// Reload reads the secret file again and, if it is valid, swaps it in and
+// returns the new secrets. On error the current secrets stay in place.
+func (l *LiveSecrets) Reload() ([]Secret, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	secrets, err := ReadSecrets(l.path)
+	if err != nil {
+		return nil, err
+	}
+	l.secrets.Store(&secrets)
+	return secrets, nil
+}
+
+// idPrefix starts a secret file line that labels its secret.
+const idPrefix = "id:"
+
+// ReadSecrets reads the webhook secrets in the file at path, one per line.
+// A line may start with id:LABEL and a space to give its secret a key ID;
+// otherwise the key ID is the line number. Blank lines are ignored, so a new
+// secret can sit alongside the old one while GitHub is switched over.
+func ReadSecrets(path string) ([]Secret, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret file: %w", err)
+	}
+
+	var secrets []Secret
+	seen := make(map[string]bool)
+	n := 0
+	for line := range strings.Lines(string(data)) {
+		n++
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+		secret := Secret{KeyID: strconv.Itoa(n), Key: []byte(line)}
+		if label, ok := strings.CutPrefix(line, idPrefix); ok {
+			id, key, _ := strings.Cut(label, " ")
+			if id == "" || key == "" {
+				return nil, fmt.Errorf("secret file %s, line %d: expected id:LABEL followed by a space and the secret", path, n)
+			}
+			secret = Secret{KeyID: id, Key: []byte(key)}
+		}
+		if seen[secret.KeyID] {
+			return nil, fmt.Errorf("secret file %s, line %d: key ID %s is used more than once", path, n, secret.KeyID)
+		}
+		seen[secret.KeyID] = true
+		secrets = append(secrets, secret)
+	}
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("secret file %s is empty", path)
+	}
+	return secrets, nil
+}
@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSecrets(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secrets")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write secret file: %v", err)
+	}
+	return path
+}
+
+func TestReadSecrets(t *testing.T) {
+	path := writeSecrets(t, "old-secret\r\n\nid:2026-10 new secret\n")
+
+	secrets, err := ReadSecrets(path)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	expected := []Secret{{KeyID: "1", Key: []byte("old-secret")}, {KeyID: "2026-10", Key: []byte("new secret")}}
+	if len(secrets) != len(expected) {
+		t.Fatalf("expected %d secrets, got %d", len(expected), len(secrets))
+	}
+	for i, want := range expected {
+		if secrets[i].KeyID != want.KeyID || string(secrets[i].Key) != string(want.Key) {
+			t.Errorf("secret %d: expected %s=%q, got %s=%q", i, want.KeyID, want.Key, secrets[i].KeyID, secrets[i].Key)
+		}
+	}
+}
+
+func TestReadSecrets_Errors(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		wantErr string
+	}{
+		"empty":           {"\n\n", "is empty"},
+		"label only":      {"id:new\n", "line 1: expected id:LABEL"},
+		"empty label":     {"id: s3cret\n", "line 1: expected id:LABEL"},
+		"duplicate label": {"id:a one\nid:a two\n", "line 2: key ID a is used more than once"},
+		"label like line": {"id:2 one\ntwo\n", "line 2: key ID 2 is used more than once"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadSecrets(writeSecrets(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	if _, err := ReadSecrets(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing secret file")
+	}
+}
+
+func TestLiveSecrets_Reload(t *testing.T) {
+	path := writeSecrets(t, "id:old old-secret\n")
+	initial, err := ReadSecrets(path)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	live := NewLiveSecrets(path, initial)
+
+	if err := os.WriteFile(path, []byte("id:old old-secret\nid:new new-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := live.Reload(); err != nil {
+		t.Fatalf("expected reload to succeed, got: %v", err)
+	}
+	if current := live.Current(); len(current) != 2 || current[1].KeyID != "new" {
+		t.Fatalf("expected the new secret to be swapped in, got %v", current)
+	}
+
+	// A broken file leaves the secrets in use alone
+	if err := os.WriteFile(path, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := live.Reload(); err == nil {
+		t.Fatal("expected reload of an empty file to fail")
+	}
+	if current := live.Current(); len(current) != 2 {
+		t.Errorf("expected the current secrets to be kept, got %v", current)
+	}
+}
@@ -7,23 +7,36 @@ import (
 	"strings"
 )
 
+// Secret is a webhook secret together with a key ID that names it in logs.
+// The key ID is a label, never derived from the key, so logs give nothing
+// away about the secret.
+type Secret struct {
+	KeyID string
+	Key   []byte
+}
+
 // VerifySignature checks if the provided signature matches the HMAC-SHA256
-// of the payload using the given secret. The signature should be in the
+// of the payload using any of the given secrets, so that a new secret can be
+// rolled out before the old one is retired. The signature should be in the
 // format "sha256=<hex-encoded-hmac>" as sent by GitHub.
-func VerifySignature(payload []byte, signature string, secret []byte) bool {
+// It returns the secret that matched.
+func VerifySignature(payload []byte, signature string, secrets []Secret) (Secret, bool) {
 	if !strings.HasPrefix(signature, "sha256=") {
-		return false
+		return Secret{}, false
 	}
 
 	sigHex := strings.TrimPrefix(signature, "sha256=")
 	sigBytes, err := hex.DecodeString(sigHex)
 	if err != nil {
-		return false
+		return Secret{}, false
 	}
 
-	mac := hmac.New(sha256.New, secret)
-	mac.Write(payload)
-	expected := mac.Sum(nil)
-
-	return hmac.Equal(sigBytes, expected)
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, secret.Key)
+		mac.Write(payload)
+		if hmac.Equal(sigBytes, mac.Sum(nil)) {
+			return secret, true
+		}
+	}
+	return Secret{}, false
 }
@@ -13,12 +13,18 @@ func computeSignature(payload, secret []byte) string {
 	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
 }
 
+// verify checks a signature against a single secret.
+func verify(payload []byte, signature string, secret []byte) bool {
+	_, ok := VerifySignature(payload, signature, []Secret{{KeyID: "1", Key: secret}})
+	return ok
+}
+
 func TestVerifySignature_Valid(t *testing.T) {
 	secret := []byte("test-secret")
 	payload := []byte(`{"action": "published"}`)
 	signature := computeSignature(payload, secret)
 
-	if !VerifySignature(payload, signature, secret) {
+	if !verify(payload, signature, secret) {
 		t.Error("expected valid signature to return true")
 	}
 }
@@ -28,7 +34,7 @@ func TestVerifySignature_Invalid(t *testing.T) {
 	payload := []byte(`{"action": "published"}`)
 	wrongSignature := "sha256=0000000000000000000000000000000000000000000000000000000000000000"
 
-	if VerifySignature(payload, wrongSignature, secret) {
+	if verify(payload, wrongSignature, secret) {
 		t.Error("expected invalid signature to return false")
 	}
 }
@@ -39,7 +45,7 @@ func TestVerifySignature_WrongSecret(t *testing.T) {
 	payload := []byte(`{"action": "published"}`)
 	signature := computeSignature(payload, wrongSecret)
 
-	if VerifySignature(payload, signature, secret) {
+	if verify(payload, signature, secret) {
 		t.Error("expected signature with wrong secret to return false")
 	}
 }
@@ -51,7 +57,7 @@ func TestVerifySignature_MissingPrefix(t *testing.T) {
 	mac.Write(payload)
 	signatureWithoutPrefix := hex.EncodeToString(mac.Sum(nil))
 
-	if VerifySignature(payload, signatureWithoutPrefix, secret) {
+	if verify(payload, signatureWithoutPrefix, secret) {
 		t.Error("expected signature without sha256= prefix to return false")
 	}
 }
@@ -60,7 +66,7 @@ func TestVerifySignature_EmptySignature(t *testing.T) {
 	secret := []byte("test-secret")
 	payload := []byte(`{"action": "published"}`)
 
-	if VerifySignature(payload, "", secret) {
+	if verify(payload, "", secret) {
 		t.Error("expected empty signature to return false")
 	}
 }
@@ -69,7 +75,30 @@ func TestVerifySignature_InvalidHex(t *testing.T) {
 	secret := []byte("test-secret")
 	payload := []byte(`{"action": "published"}`)
 
-	if VerifySignature(payload, "sha256=notvalidhex!", secret) {
+	if verify(payload, "sha256=notvalidhex!", secret) {
 		t.Error("expected invalid hex to return false")
 	}
 }
+
+func TestVerifySignature_MultipleSecrets(t *testing.T) {
+	oldSecret, newSecret := Secret{KeyID: "old", Key: []byte("old-secret")}, Secret{KeyID: "new", Key: []byte("new-secret")}
+	secrets := []Secret{oldSecret, newSecret}
+	payload := []byte(`{"action": "published"}`)
+
+	for _, want := range secrets {
+		matched, ok := VerifySignature(payload, computeSignature(payload, want.Key), secrets)
+		if !ok {
+			t.Fatalf("expected a signature made with key %s to be accepted", want.KeyID)
+		}
+		if matched.KeyID != want.KeyID {
+			t.Errorf("expected key %s to match, got %s", want.KeyID, matched.KeyID)
+		}
+	}
+
+	if _, ok := VerifySignature(payload, computeSignature(payload, []byte("retired")), secrets); ok {
+		t.Error("expected a signature made with another secret to be rejected")
+	}
+	if _, ok := VerifySignature(payload, computeSignature(payload, nil), nil); ok {
+		t.Error("expected no secrets to accept nothing")
+	}
+}
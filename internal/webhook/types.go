@@ -1,5 +1,7 @@
 package webhook
 
+import "github.com/jc/steakpie/internal/event"
+
 // RegistryPackageEvent represents a GitHub registry_package webhook payload.
 type RegistryPackageEvent struct {
 	Action          string          `json:"action"`
@@ -43,3 +45,27 @@ type Repository struct {
 type Sender struct {
 	Login string `json:"login"`
 }
+
+// Event returns the details of the delivery that commands get to see.
+// The payload is the raw, verified request body.
+func (e RegistryPackageEvent) Event(deliveryID string, payload []byte) event.Event {
+	version := e.RegistryPackage.PackageVersion
+	return event.Event{
+		Action: e.Action,
+		Package: event.Package{
+			Name:      e.RegistryPackage.Name,
+			Ecosystem: e.RegistryPackage.Ecosystem,
+		},
+		Version: event.Version{
+			ID:     version.ID,
+			SHA:    version.Version,
+			Digest: version.ContainerMetadata.Tag.Digest,
+			URL:    version.PackageURL,
+		},
+		Tag:        version.ContainerMetadata.Tag.Name,
+		DeliveryID: deliveryID,
+		Repository: e.Repository.FullName,
+		Sender:     e.Sender.Login,
+		Payload:    payload,
+	}
+}